@@ -4,6 +4,8 @@ go 1.26.1
 
 require (
 	github.com/corona10/goimagehash v1.1.0
+	github.com/klauspost/reedsolomon v1.14.2
+	github.com/mewkiz/flac v1.0.14
 	github.com/pborman/getopt/v2 v2.1.0
 	github.com/pkg/xattr v0.4.10
 	github.com/rogpeppe/go-internal v1.13.1
@@ -13,6 +15,10 @@ require (
 )
 
 require (
+	github.com/icza/bitio v1.1.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
+	github.com/mewkiz/pkg v0.0.0-20250417130911-3f050ff8c56d // indirect
+	github.com/mewpkg/term v0.0.0-20241026122259-37a80af23985 // indirect
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/tools v0.26.0 // indirect
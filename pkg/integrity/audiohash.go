@@ -0,0 +1,299 @@
+package integrity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+)
+
+// audiohashFromFilePath calculates a sha256 checksum over only the audio data
+// held within an MP3, FLAC or Ogg (Vorbis/Opus) file.
+//
+// Tag and comment data is skipped so that retagging a file in a music player
+// does not change the checksum:
+//   - MP3 : leading/trailing ID3v2 tags, ID3v1 tags, APEv2 tags and Lyrics3v2 tags
+//   - FLAC : all metadata blocks between the 'fLaC' marker and the first audio frame
+//   - Ogg : Vorbis comment headers and Opus tag headers
+func audiohashFromFilePath(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	// Add a function to defer to ensure any issue closing the file is reported
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing file: %s\n", err)
+		}
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	fileSize := fi.Size()
+
+	// Skip over any ID3v2 tags at the start of the file, some taggers also
+	// prepend these to FLAC files
+	start, err := audioLeadingID3v2Length(f, fileSize)
+	if err != nil {
+		return "", err
+	}
+
+	magic := make([]byte, 4)
+	if _, err = f.ReadAt(magic, start); err != nil {
+		if err == io.EOF {
+			return "", errors.New("audiohash: unknown audio format")
+		}
+		return "", err
+	}
+
+	hashFunc := sha256.New()
+	switch {
+	case bytes.Equal(magic, []byte("fLaC")):
+		err = audiohashFLAC(f, start, fileSize, hashFunc)
+	case bytes.Equal(magic, []byte("OggS")):
+		err = audiohashOgg(io.NewSectionReader(f, start, fileSize-start), hashFunc)
+	case start > 0 || (magic[0] == 0xFF && magic[1]&0xE0 == 0xE0):
+		// Either we found an ID3v2 tag or an MPEG audio frame sync
+		err = audiohashMP3(f, start, fileSize, hashFunc)
+	default:
+		err = errors.New("audiohash: unknown audio format")
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hashFunc.Sum(nil)), nil
+}
+
+// audioLeadingID3v2Length returns the total length of all ID3v2 tags found at the start of the file
+func audioLeadingID3v2Length(f io.ReaderAt, fileSize int64) (int64, error) {
+	var offset int64 = 0
+	header := make([]byte, 10)
+	for offset+10 <= fileSize {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(header[0:3], []byte("ID3")) {
+			break
+		}
+		tagSize := 10 + audioSynchsafeInt(header[6:10])
+		// Footer present flag
+		if header[5]&0x10 != 0 {
+			tagSize += 10
+		}
+		offset += tagSize
+	}
+	if offset > fileSize {
+		return 0, errors.New("audiohash: ID3v2 tag larger than file")
+	}
+	return offset, nil
+}
+
+// audioTrailingTagsStart returns the offset at which any trailing tags begin
+// Tags may be stacked in any order so we keep stripping until none are found
+func audioTrailingTagsStart(f io.ReaderAt, start int64, end int64) (int64, error) {
+	buf := make([]byte, 32)
+	for {
+		found := false
+		tagsEnd := end
+
+		// ID3v1 tag, 128 bytes starting 'TAG', optionally preceded by a 227 byte 'TAG+' extended tag
+		if end-start >= 128 {
+			if _, err := f.ReadAt(buf[:4], end-128); err != nil {
+				return 0, err
+			}
+			if bytes.Equal(buf[:3], []byte("TAG")) {
+				end -= 128
+				found = true
+				if end-start >= 227 {
+					if _, err := f.ReadAt(buf[:4], end-227); err != nil {
+						return 0, err
+					}
+					if bytes.Equal(buf[:4], []byte("TAG+")) {
+						end -= 227
+					}
+				}
+			}
+		}
+
+		// APEv2 tag, 32 byte footer 'APETAGEX', size excludes the optional 32 byte header
+		if end-start >= 32 {
+			if _, err := f.ReadAt(buf[:32], end-32); err != nil {
+				return 0, err
+			}
+			if bytes.Equal(buf[:8], []byte("APETAGEX")) {
+				tagSize := int64(binary.LittleEndian.Uint32(buf[12:16]))
+				if tagSize < 32 || tagSize > end-start {
+					// The size includes the footer, so can't be smaller than it
+					return 0, errors.New("audiohash: invalid APEv2 tag size")
+				}
+				if binary.LittleEndian.Uint32(buf[20:24])&(1<<31) != 0 {
+					tagSize += 32
+				}
+				end -= tagSize
+				found = true
+			}
+		}
+
+		// Lyrics3v2 tag, ends with a 6 digit size followed by 'LYRICS200'
+		if end-start >= 15 {
+			if _, err := f.ReadAt(buf[:15], end-15); err != nil {
+				return 0, err
+			}
+			if bytes.Equal(buf[6:15], []byte("LYRICS200")) {
+				if tagSize, err := strconv.ParseInt(string(buf[:6]), 10, 64); err == nil {
+					end -= tagSize + 15
+					found = true
+				}
+			}
+		}
+
+		// Appended ID3v2 tag, identified by a '3DI' footer
+		if end-start >= 10 {
+			if _, err := f.ReadAt(buf[:10], end-10); err != nil {
+				return 0, err
+			}
+			if bytes.Equal(buf[:3], []byte("3DI")) {
+				end -= 20 + audioSynchsafeInt(buf[6:10])
+				found = true
+			}
+		}
+
+		if end < start {
+			return 0, errors.New("audiohash: trailing tag larger than file")
+		}
+		if !found {
+			return end, nil
+		}
+		if end >= tagsEnd {
+			// Every tag removes at least its footer, stop rather than looking at the same tag forever
+			return 0, errors.New("audiohash: invalid trailing tag")
+		}
+	}
+}
+
+// audioSynchsafeInt decodes the 28 bit synchsafe integers used within ID3v2 headers
+func audioSynchsafeInt(b []byte) int64 {
+	return int64(b[0]&0x7F)<<21 | int64(b[1]&0x7F)<<14 | int64(b[2]&0x7F)<<7 | int64(b[3]&0x7F)
+}
+
+// audiohashMP3 hashes the MPEG audio frames between any leading and trailing tags
+func audiohashMP3(f io.ReaderAt, start int64, fileSize int64, hashFunc hash.Hash) error {
+	end, err := audioTrailingTagsStart(f, start, fileSize)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(hashFunc, io.NewSectionReader(f, start, end-start))
+	return err
+}
+
+// audiohashFLAC skips the FLAC metadata blocks and hashes the audio frames that follow
+func audiohashFLAC(f io.ReaderAt, start int64, fileSize int64, hashFunc hash.Hash) error {
+	// Skip the 'fLaC' marker
+	offset := start + 4
+	header := make([]byte, 4)
+	for {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			if err == io.EOF {
+				return errors.New("audiohash: truncated FLAC metadata")
+			}
+			return err
+		}
+		blockLength := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4 + blockLength
+		// Last metadata block flag
+		if header[0]&0x80 != 0 {
+			break
+		}
+	}
+	if offset > fileSize {
+		return errors.New("audiohash: truncated FLAC metadata")
+	}
+	end, err := audioTrailingTagsStart(f, offset, fileSize)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(hashFunc, io.NewSectionReader(f, offset, end-offset))
+	return err
+}
+
+// oggStream holds the packet reassembly state for a single logical Ogg bitstream
+type oggStream struct {
+	packet      []byte
+	packetCount int
+	codec       string
+}
+
+// audiohashOgg reassembles the packets from each Ogg page and hashes every
+// packet other than the Vorbis comment or Opus tags header.
+// Packets are hashed rather than pages as the page layout, sequence numbers and
+// CRCs all change when the comment header changes size.
+func audiohashOgg(r io.Reader, hashFunc hash.Hash) error {
+	reader := bufio.NewReader(r)
+	streams := make(map[uint32]*oggStream)
+	header := make([]byte, 27)
+	lengthBytes := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("audiohash: truncated Ogg page : %w", err)
+		}
+		if !bytes.Equal(header[0:4], []byte("OggS")) {
+			return errors.New("audiohash: invalid Ogg page")
+		}
+		serial := binary.LittleEndian.Uint32(header[14:18])
+		segmentTable := make([]byte, header[26])
+		if _, err := io.ReadFull(reader, segmentTable); err != nil {
+			return fmt.Errorf("audiohash: truncated Ogg page : %w", err)
+		}
+
+		stream, exists := streams[serial]
+		if !exists {
+			stream = &oggStream{}
+			streams[serial] = stream
+		}
+
+		for _, lacing := range segmentTable {
+			segment := make([]byte, lacing)
+			if _, err := io.ReadFull(reader, segment); err != nil {
+				return fmt.Errorf("audiohash: truncated Ogg page : %w", err)
+			}
+			stream.packet = append(stream.packet, segment...)
+			// A lacing value of less than 255 marks the end of a packet
+			if lacing < 255 {
+				if stream.packetCount == 0 {
+					if bytes.HasPrefix(stream.packet, []byte("\x01vorbis")) {
+						stream.codec = "vorbis"
+					} else if bytes.HasPrefix(stream.packet, []byte("OpusHead")) {
+						stream.codec = "opus"
+					}
+				}
+				isComment := (stream.codec == "vorbis" && bytes.HasPrefix(stream.packet, []byte("\x03vorbis"))) ||
+					(stream.codec == "opus" && bytes.HasPrefix(stream.packet, []byte("OpusTags")))
+				if !isComment {
+					// Include the packet length so packet boundaries form part of the checksum
+					binary.LittleEndian.PutUint64(lengthBytes, uint64(len(stream.packet)))
+					hashFunc.Write(lengthBytes)
+					hashFunc.Write(stream.packet)
+				}
+				stream.packet = stream.packet[:0]
+				stream.packetCount++
+			}
+		}
+	}
+	if len(streams) == 0 {
+		return errors.New("audiohash: no Ogg pages found")
+	}
+	return nil
+}
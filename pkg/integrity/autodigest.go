@@ -0,0 +1,141 @@
+package integrity
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policy used by --digest=auto when none is given with --auto-policy or INTEGRITY_AUTO_POLICY
+// sha256 for everything, plus phash for the image formats it can decode (not HEIC or AVIF),
+// oshash for video and audiohash for supported audio
+const defaultAutoPolicy = "*=sha256;image/jpeg,image/png,image/gif,image/bmp,image/tiff,image/webp=sha256,phash;video/*=sha256,oshash;audio/mpeg,audio/flac,audio/ogg=sha256,audiohash"
+
+// parseAutoPolicy parses a policy string of the form 'match[,match]=digest[,digest];...'
+// where each match is one of:
+//
+//   - '*' the default for files that match nothing else
+//   - 'image/png' an exact MIME type
+//   - 'image/*' any MIME type within the given top level type
+//   - '.mp4' a file extension (case insensitive)
+func parseAutoPolicy(policyString string) (map[string][]string, error) {
+	policy := make(map[string][]string)
+	for _, rule := range strings.Split(policyString, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		matches, digests, found := strings.Cut(rule, "=")
+		if !found || strings.TrimSpace(matches) == "" || strings.TrimSpace(digests) == "" {
+			return nil, fmt.Errorf("invalid auto policy rule '%s'", rule)
+		}
+		var digestNames []string
+		for _, digestName := range strings.Split(digests, ",") {
+			digestNames = append(digestNames, strings.TrimSpace(digestName))
+		}
+		for _, match := range strings.Split(matches, ",") {
+			match = strings.ToLower(strings.TrimSpace(match))
+			policy[match] = digestNames
+		}
+	}
+	if len(policy) == 0 {
+		return nil, fmt.Errorf("empty auto policy")
+	}
+	return policy, nil
+}
+
+// integ_autoDigestNames returns the digests the auto policy selects for a file
+// Matches are tried from most to least specific: extension, exact MIME type, MIME type wildcard, default
+func integ_autoDigestNames(currentFile *integrity_fileCard) ([]string, error) {
+	if digestNames, exists := config.autoPolicy[strings.ToLower(filepath.Ext(currentFile.fullpath))]; exists {
+		return digestNames, nil
+	}
+	contentType, err := integ_sniffContentType(currentFile.fullpath)
+	if err != nil {
+		return nil, err
+	}
+	config.log("debug", "integ_autoDigestNames contentType:%s\n", contentType)
+	if digestNames, exists := config.autoPolicy[contentType]; exists {
+		return digestNames, nil
+	}
+	if mainType, _, found := strings.Cut(contentType, "/"); found {
+		if digestNames, exists := config.autoPolicy[mainType+"/*"]; exists {
+			return digestNames, nil
+		}
+	}
+	if digestNames, exists := config.autoPolicy["*"]; exists {
+		return digestNames, nil
+	}
+	return []string{}, nil
+}
+
+// integ_sniffContentType works out a file's MIME type from the magic bytes at the start of the file
+func integ_sniffContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	// Add a function to defer to ensure any issue closing the file is reported
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing file: %s\n", err)
+		}
+	}()
+
+	// 512 bytes is all http.DetectContentType will consider
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	// Formats not covered by http.DetectContentType
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		// ISO base media files, the major brand tells us what they contain
+		switch string(header[8:12]) {
+		case "heic", "heix", "mif1", "msf1":
+			return "image/heic", nil
+		case "avif":
+			return "image/avif", nil
+		case "M4A ", "M4B ":
+			return "audio/mp4", nil
+		default:
+			return "video/mp4", nil
+		}
+	case bytes.HasPrefix(header, []byte("\x1a\x45\xdf\xa3")):
+		if bytes.Contains(header, []byte("webm")) {
+			return "video/webm", nil
+		}
+		return "video/x-matroska", nil
+	case bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "image/tiff", nil
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "audio/flac", nil
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync without an ID3 tag
+		return "audio/mpeg", nil
+	case len(header) > 188 && header[0] == 0x47 && header[188] == 0x47:
+		return "video/mp2t", nil
+	}
+
+	contentType, _, _ := strings.Cut(http.DetectContentType(header), ";")
+	if contentType == "application/ogg" {
+		contentType = "audio/ogg"
+	}
+	if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") || strings.HasPrefix(contentType, "audio/") {
+		return contentType, nil
+	}
+
+	// Fall back to any other registered image decoders
+	if _, format, err := image.DecodeConfig(bytes.NewReader(header)); err == nil {
+		return "image/" + format, nil
+	}
+	return contentType, nil
+}
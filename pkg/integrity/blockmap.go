@@ -0,0 +1,275 @@
+package integrity
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Default size of each block hashed by the blockmap digest
+const blockmapDefaultBlockSize = 4 * 1024 * 1024 // 4MB
+
+// Number of bytes of each block's sha256 kept in the map, enough to locate corruption
+// while the file's other digests remain responsible for verifying the whole file
+const blockmapHashSize = 8
+
+// Blockmaps larger than this are written to a sidecar file rather than the extended attribute
+// as most filesystems limit the size of an extended attribute value (ext4 to a single block)
+const blockmapXattrMaxSize = 2048
+
+// Largest block size allowed, each block is read into memory while hashing or repairing a file
+const blockmapMaxBlockSize = 1024 * 1024 * 1024
+
+// Suffix of the hidden sidecar file written next to a file when its blockmap is too large
+const blockmapSidecarSuffix = ".integrity-blockmap"
+
+// Prefix of the extended attribute value pointing at a sidecar file
+const blockmapSidecarPrefix = "sidecar:"
+
+// blockmap holds a truncated sha256 for every block of a file
+// it is stored as 'v1:<block size>:<file size>:<base64 zlib compressed hashes>'
+type blockmap struct {
+	blockSize int64
+	fileSize  int64
+	hashes    []byte
+}
+
+func (b *blockmap) String() string {
+	var compressed bytes.Buffer
+	zlibWriter := zlib.NewWriter(&compressed)
+	zlibWriter.Write(b.hashes)
+	zlibWriter.Close()
+	return fmt.Sprintf("v1:%d:%d:%s", b.blockSize, b.fileSize, base64.StdEncoding.EncodeToString(compressed.Bytes()))
+}
+
+func parseBlockmap(value string) (*blockmap, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) != 4 || fields[0] != "v1" {
+		return nil, errors.New("blockmap: unknown blockmap format")
+	}
+	var err error
+	b := &blockmap{}
+	if b.blockSize, err = strconv.ParseInt(fields[1], 10, 64); err != nil || b.blockSize <= 0 || b.blockSize > blockmapMaxBlockSize {
+		return nil, errors.New("blockmap: invalid block size")
+	}
+	if b.fileSize, err = strconv.ParseInt(fields[2], 10, 64); err != nil || b.fileSize < 0 {
+		return nil, errors.New("blockmap: invalid file size")
+	}
+	compressed, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("blockmap: %w", err)
+	}
+	zlibReader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("blockmap: %w", err)
+	}
+	if b.hashes, err = io.ReadAll(zlibReader); err != nil {
+		return nil, fmt.Errorf("blockmap: %w", err)
+	}
+	if int64(len(b.hashes)) != blockmapBlockCount(b.fileSize, b.blockSize)*blockmapHashSize {
+		return nil, errors.New("blockmap: hash count does not match file size")
+	}
+	return b, nil
+}
+
+func blockmapBlockCount(fileSize int64, blockSize int64) int64 {
+	return (fileSize + blockSize - 1) / blockSize
+}
+
+// blockmapFromFilePath calculates the blockmap of a file
+// When checking an existing blockmap the stored block size is used so the two maps can be compared block by block
+func blockmapFromFilePath(filePath string) (string, error) {
+	blockSize := config.blockSize
+	if config.Action != "add" {
+		if storedValue, err := integ_getChecksumRaw(filePath); err == nil {
+			if storedValue, err = blockmapResolveSidecar(filePath, storedValue); err == nil {
+				if stored, err := parseBlockmap(storedValue); err == nil {
+					blockSize = stored.blockSize
+				}
+			}
+		}
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	// Add a function to defer to ensure any issue closing the file is reported
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing file: %s\n", err)
+		}
+	}()
+
+	b := &blockmap{blockSize: blockSize}
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(f, block)
+		if n > 0 {
+			blockHash := sha256.Sum256(block[:n])
+			b.hashes = append(b.hashes, blockHash[:blockmapHashSize]...)
+			b.fileSize += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// blockmapSidecarPath returns the path of the sidecar file for a file
+func blockmapSidecarPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+blockmapSidecarSuffix)
+}
+
+// blockmapStoreValue returns the value to write to the extended attribute for a blockmap
+// large blockmaps are written to a sidecar file and the attribute points at the sidecar and its sha256
+func blockmapStoreValue(filePath string, value string) (string, error) {
+	sidecarPath := blockmapSidecarPath(filePath)
+	if len(value) <= blockmapXattrMaxSize {
+		// Remove any sidecar left over from a previous, larger blockmap
+		if err := os.Remove(sidecarPath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return value, nil
+	}
+	if err := os.WriteFile(sidecarPath, []byte(value), 0644); err != nil {
+		return "", err
+	}
+	sidecarHash := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("%s%s:%s", blockmapSidecarPrefix, filepath.Base(sidecarPath), hex.EncodeToString(sidecarHash[:])), nil
+}
+
+// blockmapResolveSidecar returns the blockmap an extended attribute value refers to
+// reading and verifying the sidecar file if the value points at one
+func blockmapResolveSidecar(filePath string, value string) (string, error) {
+	if !strings.HasPrefix(value, blockmapSidecarPrefix) {
+		return value, nil
+	}
+	sidecarName, sidecarHashString, found := strings.Cut(strings.TrimPrefix(value, blockmapSidecarPrefix), ":")
+	if !found || sidecarName != filepath.Base(sidecarName) {
+		return "", errors.New("blockmap: invalid sidecar reference")
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(filePath), sidecarName))
+	if err != nil {
+		return "", fmt.Errorf("blockmap: %w", err)
+	}
+	sidecarHash := sha256.Sum256(data)
+	if hex.EncodeToString(sidecarHash[:]) != sidecarHashString {
+		return "", fmt.Errorf("blockmap: sidecar file %s is corrupt", sidecarName)
+	}
+	return string(data), nil
+}
+
+// blockmapRemoveSidecar removes the sidecar file an extended attribute value points at, if any
+func blockmapRemoveSidecar(filePath string, value string) error {
+	if !strings.HasPrefix(value, blockmapSidecarPrefix) {
+		return nil
+	}
+	sidecarName, _, _ := strings.Cut(strings.TrimPrefix(value, blockmapSidecarPrefix), ":")
+	if sidecarName != filepath.Base(sidecarName) {
+		return errors.New("blockmap: invalid sidecar reference")
+	}
+	if err := os.Remove(filepath.Join(filepath.Dir(filePath), sidecarName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// blockmapConfirm compares a stored blockmap against a calculated one
+// returning an error listing the corrupt byte ranges if they differ
+func blockmapConfirm(filePath string, storedValue string, calculatedValue string) error {
+	storedValue, err := blockmapResolveSidecar(filePath, storedValue)
+	if err != nil {
+		return err
+	}
+	if storedValue == calculatedValue {
+		return nil
+	}
+	stored, err := parseBlockmap(storedValue)
+	if err != nil {
+		return err
+	}
+	calculated, err := parseBlockmap(calculatedValue)
+	if err != nil {
+		return err
+	}
+	if stored.blockSize != calculated.blockSize {
+		return fmt.Errorf("blockmap: block size differs, stored [%d] calc'd [%d]", stored.blockSize, calculated.blockSize)
+	}
+
+	// Build a list of corrupt byte ranges, merging neighbouring blocks
+	var ranges []string
+	var rangeStart int64 = -1
+	var rangeEnd int64
+	commonBlocks := min(len(stored.hashes), len(calculated.hashes)) / blockmapHashSize
+	for block := 0; block < commonBlocks; block++ {
+		offset := block * blockmapHashSize
+		blockStart := int64(block) * stored.blockSize
+		blockEnd := min(blockStart+stored.blockSize, stored.fileSize, calculated.fileSize) - 1
+		if bytes.Equal(stored.hashes[offset:offset+blockmapHashSize], calculated.hashes[offset:offset+blockmapHashSize]) {
+			continue
+		}
+		if rangeStart >= 0 && rangeEnd+1 == blockStart {
+			rangeEnd = blockEnd
+			continue
+		}
+		if rangeStart >= 0 {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", rangeStart, rangeEnd))
+		}
+		rangeStart, rangeEnd = blockStart, blockEnd
+	}
+	if rangeStart >= 0 {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", rangeStart, rangeEnd))
+	}
+
+	if len(ranges) == 0 && stored.fileSize == calculated.fileSize {
+		// Every block matches, the values only differ in how the hashes were encoded
+		return nil
+	}
+	message := "corrupt byte ranges : " + strings.Join(ranges, ", ")
+	if len(ranges) == 0 {
+		message = "no corrupt blocks"
+	}
+	if stored.fileSize != calculated.fileSize {
+		message += fmt.Sprintf(" : file size changed from %d to %d bytes", stored.fileSize, calculated.fileSize)
+	}
+	return errors.New(message)
+}
+
+// parseByteSize parses a size in bytes with an optional k, m or g suffix, e.g. 64k, 4M
+func parseByteSize(sizeString string) (int64, error) {
+	multiplier := int64(1)
+	numberString := strings.TrimSpace(sizeString)
+	switch strings.ToLower(numberString[max(len(numberString)-1, 0):]) {
+	case "k":
+		multiplier = 1024
+	case "m":
+		multiplier = 1024 * 1024
+	case "g":
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		numberString = numberString[:len(numberString)-1]
+	}
+	size, err := strconv.ParseInt(numberString, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size '%s'", sizeString)
+	}
+	if size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size '%s' is too large", sizeString)
+	}
+	return size * multiplier, nil
+}
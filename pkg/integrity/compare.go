@@ -0,0 +1,102 @@
+package integrity
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// integ_handleCompare compares the files below two directories by relative path, e.g. to verify a copy or backup
+// Files are compared using their stored checksums, calculating any which are missing, or
+// recalculating all of them with --rehash
+func integ_handleCompare(srcPath string, dstPath string) {
+	for _, sidePath := range []string{srcPath, dstPath} {
+		fileinfo, err := os.Stat(sidePath)
+		if err != nil {
+			displayFileErrorMessageNoDigest(sidePath, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		} else if !fileinfo.IsDir() {
+			config.log("error", "Error : --compare takes a source and a destination directory\n")
+			config.returnCode = 19 // Invalid compare options
+			return
+		}
+	}
+
+	for _, digestName := range config.digestNames {
+		config.DigestName = digestName
+		config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+		config.log("debug", "compare: '%s'\n", config.xattribute_fullname)
+
+		srcManifest, err := integ_scanManifest(srcPath, "", nil)
+		if err != nil {
+			displayFileErrorMessage(srcPath, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		}
+		dstManifest, err := integ_scanManifest(dstPath, "", nil)
+		if err != nil {
+			displayFileErrorMessage(dstPath, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		}
+
+		dstByPath := make(map[string]manifestEntry)
+		for _, entry := range dstManifest.Files {
+			dstByPath[entry.Path] = entry
+		}
+		var identical, differing, onlyInSrc, onlyInDst []string
+		for _, srcEntry := range srcManifest.Files {
+			dstEntry, exists := dstByPath[srcEntry.Path]
+			if !exists {
+				onlyInSrc = append(onlyInSrc, srcEntry.Path)
+				continue
+			}
+			delete(dstByPath, srcEntry.Path)
+			if srcEntry.Size == dstEntry.Size && srcEntry.Checksum == dstEntry.Checksum {
+				identical = append(identical, srcEntry.Path)
+			} else {
+				differing = append(differing, srcEntry.Path)
+			}
+		}
+		for relativePath := range dstByPath {
+			onlyInDst = append(onlyInDst, relativePath)
+		}
+		sort.Strings(identical)
+		sort.Strings(differing)
+		sort.Strings(onlyInSrc)
+		sort.Strings(onlyInDst)
+
+		if config.VerboseLevel == 2 {
+			for _, relativePath := range identical {
+				displayFileMessage(relativePath, "identical")
+			}
+		}
+		// Always output differences even if we're 'quiet'
+		for _, relativePath := range differing {
+			displayFileErrorMessage(relativePath, "DIFFERS")
+		}
+		for _, relativePath := range onlyInSrc {
+			displayFileErrorMessage(relativePath, fmt.Sprintf("only in %s", srcPath))
+		}
+		for _, relativePath := range onlyInDst {
+			displayFileErrorMessage(relativePath, fmt.Sprintf("only in %s", dstPath))
+		}
+
+		summary := fmt.Sprintf("%d identical, %d differing, %d only in %s, %d only in %s",
+			len(identical), len(differing), len(onlyInSrc), srcPath, len(onlyInDst), dstPath)
+		if len(differing)+len(onlyInSrc)+len(onlyInDst) > 0 {
+			displayFileErrorMessage(dstPath, fmt.Sprintf("COMPARE FAILED : %s", summary))
+			config.returnCode = 29 // Differences found
+		} else {
+			switch config.VerboseLevel {
+			case 0:
+				// Don't print anything we're 'quiet'
+			case 1:
+				displayFileMessage(dstPath, "COMPARE PASSED")
+			case 2:
+				displayFileMessage(dstPath, fmt.Sprintf("COMPARE PASSED : %s", summary))
+			}
+		}
+	}
+}
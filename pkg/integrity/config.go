@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 
@@ -55,37 +56,80 @@ var digestTypes = map[string]crypto.Hash{
 	"blake2b_512": crypto.BLAKE2b_512,
 }
 
+// File type specific digests that don't come from crypto.Hash
+var fileDigestTypes = map[string]func(string) (string, error){
+	"oshash":    oshashFromFilePath,
+	"phash":     integrityPhashFromFile,
+	"audiohash": audiohashFromFilePath,
+	"blockmap":  blockmapFromFilePath,
+}
+
 type Config struct {
-	ShowHelp            bool
-	ShowVersion         bool
-	ShowInfo            bool
-	ShowUsage           bool
-	showProgress        bool
-	Verbose             bool
-	Quiet               bool
-	VerboseLevel        int
-	DigestHash          crypto.Hash
-	DigestName          string
-	Action              string
-	DisplayFormat       string
-	Action_Add          bool
-	Action_Delete       bool
-	Action_List         bool
-	Action_Transform    bool
-	Action_Check        bool
-	Option_Force        bool
-	Option_ShortPaths   bool
-	Option_Recursive    bool
-	Option_AllDigests   bool
-	xattribute_fullname string
-	xattribute_prefix   string
-	logLevelName        string
-	logLevel            logLevel
-	returnCode          int // used to store a return code for the cmd util
-	digestList          map[string]crypto.Hash
-	digestNames         []string
-	binaryDigestName    string
-	isTerminal          bool
+	ShowHelp               bool
+	ShowVersion            bool
+	ShowInfo               bool
+	ShowUsage              bool
+	showProgress           bool
+	Verbose                bool
+	Quiet                  bool
+	VerboseLevel           int
+	DigestHash             crypto.Hash
+	DigestName             string
+	Action                 string
+	Subcommand             string
+	DisplayFormat          string
+	Action_Add             bool
+	Action_Delete          bool
+	Action_List            bool
+	Action_Transform       bool
+	Action_Check           bool
+	Action_AddParity       bool
+	Action_Repair          bool
+	Option_Force           bool
+	Option_ShortPaths      bool
+	relativeTo             string
+	Option_Recursive       bool
+	Option_AllDigests      bool
+	Option_Tree            bool
+	Option_Moves           bool
+	Option_Compare         bool
+	Option_Rehash          bool
+	Option_CopyAttrs       bool
+	Option_Duplicates      bool
+	Option_JSON            bool
+	Option_DedupeHardlink  bool
+	Option_DryRun          bool
+	Option_HashMissing     bool
+	Option_SkipSystemFiles bool
+	Option_SkipHidden      bool
+	Option_OneFileSystem   bool
+	filesFromPath          string
+	Option_Null            bool
+	Option_Zero            bool
+	Option_Stdin           bool
+	symlinkMode            string
+	findChecksum           string
+	findSize               int64
+	digestGiven            bool
+	excludeRules           ignoreRules
+	includeRules           ignoreRules
+	Option_ValidateFormat  bool
+	Option_AutoDigest      bool
+	autoPolicy             map[string][]string
+	blockSize              int64
+	parityRedundancy       int
+	snapshotPath           string
+	xattribute_fullname    string
+	xattribute_prefix      string
+	logLevelName           string
+	logLevel               logLevel
+	returnCode             int                         // used to store a return code for the cmd util
+	hardlinks              map[inodeKey]hardlinkRecord // first path handled for each file with several hard links
+	walkErrors             []walkError                 // paths skipped because of errors, summarised at the end of the run
+	digestList             map[string]crypto.Hash
+	digestNames            []string
+	binaryDigestName       string
+	isTerminal             bool
 }
 
 // Logging function, only outputs if the log level is less than or equal to the current log level
@@ -121,36 +165,68 @@ func (c *Config) log(level string, format string, args ...interface{}) {
 
 func newConfig() *Config {
 	var c *Config = &Config{
-		ShowHelp:            false,
-		ShowVersion:         false,
-		ShowInfo:            false,
-		ShowUsage:           false,
-		showProgress:        false,
-		Action_Check:        false,
-		Action_Add:          false,
-		Action_Delete:       false,
-		Action_List:         false,
-		Action_Transform:    false,
-		Option_Force:        false,
-		Option_ShortPaths:   false,
-		Option_Recursive:    false,
-		Option_AllDigests:   false,
-		Verbose:             false,
-		Quiet:               false,
-		VerboseLevel:        1,
-		DigestHash:          crypto.SHA1,
-		DigestName:          "",
-		DisplayFormat:       "",
-		Action:              "check",
-		xattribute_fullname: "",
-		xattribute_prefix:   "",
-		logLevelName:        "info",
-		logLevel:            logLevelInfo,
-		returnCode:          0,
-		digestList:          make(map[string]crypto.Hash),
-		digestNames:         make([]string, 0),
-		binaryDigestName:    "",
-		isTerminal:          term.IsTerminal(int(os.Stdout.Fd())),
+		ShowHelp:               false,
+		ShowVersion:            false,
+		ShowInfo:               false,
+		ShowUsage:              false,
+		showProgress:           false,
+		Action_Check:           false,
+		Action_Add:             false,
+		Action_Delete:          false,
+		Action_List:            false,
+		Action_Transform:       false,
+		Action_AddParity:       false,
+		Action_Repair:          false,
+		Option_Force:           false,
+		Option_ShortPaths:      false,
+		relativeTo:             "",
+		Option_Recursive:       false,
+		Option_AllDigests:      false,
+		Option_Tree:            false,
+		Option_Moves:           false,
+		Option_Compare:         false,
+		Option_Rehash:          false,
+		Option_CopyAttrs:       false,
+		Option_Duplicates:      false,
+		Option_JSON:            false,
+		Option_DedupeHardlink:  false,
+		Option_DryRun:          false,
+		Option_HashMissing:     false,
+		Option_SkipSystemFiles: false,
+		Option_SkipHidden:      false,
+		Option_OneFileSystem:   false,
+		filesFromPath:          "",
+		Option_Null:            false,
+		Option_Zero:            false,
+		Option_Stdin:           false,
+		symlinkMode:            "skip",
+		findChecksum:           "",
+		findSize:               -1,
+		digestGiven:            false,
+		Option_ValidateFormat:  false,
+		Option_AutoDigest:      false,
+		autoPolicy:             make(map[string][]string),
+		blockSize:              blockmapDefaultBlockSize,
+		parityRedundancy:       10,
+		snapshotPath:           "",
+		Verbose:                false,
+		Quiet:                  false,
+		VerboseLevel:           1,
+		DigestHash:             crypto.SHA1,
+		DigestName:             "",
+		DisplayFormat:          "",
+		Action:                 "check",
+		Subcommand:             "",
+		xattribute_fullname:    "",
+		xattribute_prefix:      "",
+		logLevelName:           "info",
+		logLevel:               logLevelInfo,
+		returnCode:             0,
+		hardlinks:              make(map[inodeKey]hardlinkRecord),
+		digestList:             make(map[string]crypto.Hash),
+		digestNames:            make([]string, 0),
+		binaryDigestName:       "",
+		isTerminal:             term.IsTerminal(int(os.Stdout.Fd())),
 	}
 	c.parseCmdlineOpt()
 	return c
@@ -159,6 +235,12 @@ func newConfig() *Config {
 func (c *Config) parseCmdlineOpt() {
 
 	var userDigestString string
+	var autoPolicyString string
+	var blockSizeString string
+	var findSizeString string
+	var excludePatterns []string
+	var includePatterns []string
+	var excludeFromPath string
 
 	// Set the potential command line options
 	getopt.FlagLong(&c.ShowHelp, "help", 'h', "show this help")
@@ -170,17 +252,57 @@ func (c *Config) parseCmdlineOpt() {
 	getopt.FlagLong(&c.Action_Delete, "delete", 'd', "delete a checksum stored for a file")
 	getopt.FlagLong(&c.Action_List, "list", 'l', "list the checksum stored for a file")
 	getopt.FlagLong(&c.Action_Transform, "fix-old", 0, "fix an old extended attribute value name to the current format")
+	getopt.FlagLong(&c.Action_AddParity, "add-parity", 0, "calculate Reed-Solomon parity data for the file and store it in a hidden sidecar file next to the file")
+	getopt.FlagLong(&c.Action_Repair, "repair", 0, "use the file's parity data to repair a file which fails its stored checksum, the repaired blocks are only written back once a repaired copy matches the stored checksum")
+	getopt.FlagLong(&c.parityRedundancy, "parity-redundancy", 0, "set the size of the parity data as a percentage of the file size, used by --add-parity (default 10)")
 	getopt.FlagLong(&c.Option_AllDigests, "all", 'x', "include all digests, not just the default digest. Only applies to --delete and --list options")
 	getopt.FlagLong(&c.Option_Force, "force", 'f', "force the calculation and writing of a checksum even if one already exists (default behaviour is to skip files with checksums already stored)")
 	getopt.FlagLong(&c.showProgress, "progress", 'p', "show the progress of each file checksum calculation")
 	getopt.FlagLong(&c.Verbose, "verbose", 'v', "output more information.")
 	getopt.FlagLong(&c.Quiet, "quiet", 'q', "output less information.")
 	getopt.FlagLong(&c.logLevelName, "loglevel", 0, "set the logging level. One of: panic, fatal, error, warn, info, debug, trace.")
-	getopt.FlagLong(&userDigestString, "digest", 0, "set the digest method(s) as a comma separated list (see help for list of digest types available)")
+	getopt.FlagLong(&userDigestString, "digest", 0, "set the digest method(s) as a comma separated list (see help for list of digest types available), or 'auto' to choose digests by file type")
+	getopt.FlagLong(&autoPolicyString, "auto-policy", 0, "set the file type to digest mapping used by --digest=auto, e.g. '*=sha256;image/jpeg,image/png=sha256,phash;.mkv=sha256,oshash'")
 	getopt.FlagLong(&c.Option_ShortPaths, "short-paths", 's', "show only file name when showing file names, useful for generating sha1sum files")
+	getopt.FlagLong(&c.relativeTo, "relative-to", 0, "show file names relative to the given directory, useful for generating sha1sum files which can be checked from that directory")
 	getopt.FlagLong(&c.Option_Recursive, "recursive", 'r', "recurse into sub-directories")
+	getopt.FlagLong(&blockSizeString, "block-size", 0, "set the block size used by the blockmap digest and the largest block size used by --add-parity, e.g. 64k, 4M (default 4M, at most 1G)")
+	getopt.FlagLong(&c.Option_ValidateFormat, "validate-format", 0, "when checking files without a stored checksum, verify them using the checks built into their file format (zip, gzip, png, flac, jpeg)")
+	getopt.FlagLong(&c.Option_Tree, "tree", 0, "add, check, list or delete a Merkle tree checksum of a directory, built from the stored checksums of everything below it and stored on the directory itself")
+	getopt.FlagLong(&c.snapshotPath, "snapshot", 0, "with --add, save the path, size and checksum of every file below a directory to a snapshot file. With --check, compare the directory against the snapshot and report MISSING, NEW, RENAMED and CHANGED files. Stored checksums are trusted unless the file's size or modification time has changed, or --rehash is given")
+	getopt.FlagLong(&c.Option_Moves, "moves", 0, "compare an old and a new directory, or snapshot file, and report files which have been moved or renamed by matching their size and checksum")
+	getopt.FlagLong(&c.Option_Compare, "compare", 0, "compare the files below a source and a destination directory by relative path using their stored checksums, e.g. to verify a copy or backup")
+	getopt.FlagLong(&c.Option_Rehash, "rehash", 0, "recalculate checksums rather than using the stored checksums when comparing files")
+	getopt.FlagLong(&c.Option_CopyAttrs, "copy-attrs", 0, "copy the checksums stored for the files below a source directory to the files at the same paths below a destination directory, once each destination file has been verified against them")
+	getopt.FlagLong(&c.Option_Duplicates, "duplicates", 0, "find groups of duplicate files below the given paths using their stored checksums, only calculating checksums for files which share their size with another file")
+	getopt.FlagLong(&c.Option_JSON, "json", 0, "output the --duplicates report as JSON")
+	getopt.FlagLong(&c.Option_DedupeHardlink, "dedupe-hardlink", 0, "replace duplicate files below the given paths with hard links to a single copy, once they have been compared byte for byte. Only files on the same filesystem are linked")
+	getopt.FlagLong(&c.Option_DryRun, "dry-run", 0, "show what --dedupe-hardlink would do without changing any files")
+	getopt.FlagLong(&c.findChecksum, "find", 0, "find the files below the given paths with a stored checksum matching the given checksum, or checksum prefix. Every stored digest is searched unless --digest is given")
+	getopt.FlagLong(&c.Option_HashMissing, "hash-missing", 0, "calculate the checksum of files of the given --size without a stored checksum when using --find")
+	getopt.FlagLong(&findSizeString, "size", 0, "only search files of the given size in bytes when using --find, e.g. 1048576, 1M")
+	getopt.FlagLong(&excludePatterns, "exclude", 0, "skip files and directories matching the given gitignore style pattern when walking directories, may be repeated")
+	getopt.FlagLong(&excludeFromPath, "exclude-from", 0, "skip files and directories matching any of the gitignore style patterns in the given file. .integrityignore files are also honoured during walks")
+	getopt.FlagLong(&includePatterns, "include", 0, "only process files matching the given pattern when walking directories, may be repeated")
+	getopt.FlagLong(&c.Option_SkipSystemFiles, "skip-system-files", 0, "skip the metadata files created by macOS, Windows and filesystems when walking directories (.DS_Store, ._* AppleDouble files, .Spotlight-V100, .Trashes, .fseventsd, Thumbs.db, desktop.ini, lost+found)")
+	getopt.FlagLong(&c.Option_SkipHidden, "skip-hidden", 0, "skip hidden files and directories, those starting with '.', when walking directories")
+	getopt.FlagLong(&c.Option_OneFileSystem, "one-file-system", 0, "don't cross into other filesystems, such as mounted volumes, when walking directories")
+	getopt.FlagLong(&c.symlinkMode, "symlinks", 0, "how to handle symlinks found when walking directories: skip (the default), follow, or record the checksum of the link's target path on the link itself")
+	getopt.FlagLong(&c.filesFromPath, "files-from", 0, "read the paths to process from the given file, one per line, or from stdin if the file is '-'")
+	getopt.FlagLong(&c.Option_Null, "null", '0', "paths read with --files-from are separated by NUL characters rather than new lines, e.g. from find -print0")
+	getopt.FlagLong(&c.Option_Zero, "zero", 'z', "end each line of output with a NUL character rather than a new line, and don't escape file names in the sum formats")
+	getopt.FlagLong(&c.Option_Stdin, "stdin", 0, "print the checksum of the data read from stdin rather than of files")
 	getopt.FlagLong(&c.DisplayFormat, "display-format", 0, "set the output display format (sha1sum, md5sum). Note: this only shows any checkfiles ")
-	getopt.Parse()
+
+	// Subcommands are given before any options, e.g. integrity copy -r src/ dst/ or integrity tee out.bin
+	// Neither subcommand works without arguments, so on its own the name is taken to be a file,
+	// otherwise files named copy or tee are given as ./copy or after --
+	args := os.Args
+	if len(args) > 2 && (args[1] == "copy" || args[1] == "tee") {
+		c.Subcommand = args[1]
+		args = append([]string{args[0]}, args[2:]...)
+	}
+	getopt.CommandLine.Parse(args)
 
 	//-----------------------------------------------------------------------------------------
 	// Cover the help displays with exits first
@@ -207,7 +329,7 @@ func (c *Config) parseCmdlineOpt() {
 	//-----------------------------------------------------------------------------------------
 	// Return error of no arguments are given
 	//-----------------------------------------------------------------------------------------
-	if getopt.NArgs() == 0 && !c.ShowInfo {
+	if getopt.NArgs() == 0 && c.filesFromPath == "" && !c.Option_Stdin && !c.ShowInfo {
 		fmt.Fprint(os.Stderr, "Error : no arguments given\n")
 		getopt.Usage()
 		c.returnCode = 2 // No arguments
@@ -247,6 +369,10 @@ func (c *Config) parseCmdlineOpt() {
 		c.Action = "list"
 	} else if c.Action_Transform {
 		c.Action = "transform"
+	} else if c.Action_AddParity {
+		c.Action = "add-parity"
+	} else if c.Action_Repair {
+		c.Action = "repair"
 	}
 	c.log("debug", "c.Action: '%s'\n", c.Action)
 
@@ -270,9 +396,10 @@ func (c *Config) parseCmdlineOpt() {
 		for digestName := range digestTypes {
 			c.digestNames = append(c.digestNames, digestName)
 		}
-		// Add the two digests that don't come from crypto.Hash
-		c.digestNames = append(c.digestNames, "oshash")
-		c.digestNames = append(c.digestNames, "phash")
+		// Add the digests that don't come from crypto.Hash
+		for digestName := range fileDigestTypes {
+			c.digestNames = append(c.digestNames, digestName)
+		}
 	} else {
 		// If we've not been given a string from the user, try and get it from the environment
 		if userDigestString == "" {
@@ -284,7 +411,209 @@ func (c *Config) parseCmdlineOpt() {
 			c.digestNames = []string{"sha1"}
 		} else {
 			c.digestNames = append(c.digestNames, userDigestArray...)
+			c.digestGiven = true
+		}
+	}
+
+	//-----------------------------------------------------------------------------------------
+	// Setup the block size used by block based digests
+	//-----------------------------------------------------------------------------------------
+	if blockSizeString != "" {
+		var err error
+		if c.blockSize, err = parseByteSize(blockSizeString); err != nil {
+			c.log("error", "Error : %s for --block-size\n", err.Error())
+			c.returnCode = 15 // Invalid block size
+			return
+		}
+		if c.blockSize > blockmapMaxBlockSize {
+			c.log("error", "Error : size '%s' is larger than the 1G limit for --block-size\n", blockSizeString)
+			c.returnCode = 15 // Invalid block size
+			return
+		}
+	}
+
+	if findSizeString != "" {
+		var err error
+		if c.findSize, err = parseByteSize(findSizeString); err != nil {
+			c.log("error", "Error : %s for --size\n", err.Error())
+			c.returnCode = 23 // Invalid find size
+			return
+		}
+	}
+	// Hashing every file without a stored checksum would read the whole tree, only hash those of the size searched for
+	if c.Option_HashMissing && c.findSize < 0 {
+		c.log("error", "Error : --hash-missing needs the --size of the file to find\n")
+		c.returnCode = 23 // Invalid find size
+		return
+	}
+
+	//-----------------------------------------------------------------------------------------
+	// Setup the patterns used to filter directory walks
+	//-----------------------------------------------------------------------------------------
+	if excludeFromPath != "" {
+		rules, err := readIgnoreFile(excludeFromPath)
+		if err == nil && rules == nil {
+			_, err = os.Stat(excludeFromPath)
+		}
+		if err != nil {
+			c.log("error", "Error : %s for --exclude-from\n", err.Error())
+			c.returnCode = 24 // Invalid filter pattern
+			return
+		}
+		c.excludeRules = append(c.excludeRules, rules...)
+	}
+	if len(excludePatterns) > 0 || len(includePatterns) > 0 {
+		rules, err := parseIgnoreRules(excludePatterns)
+		if err == nil {
+			c.excludeRules = append(c.excludeRules, rules...)
+			c.includeRules, err = parseIgnoreRules(includePatterns)
 		}
+		if err != nil {
+			c.log("error", "Error : %s\n", err.Error())
+			c.returnCode = 24 // Invalid filter pattern
+			return
+		}
+	}
+
+	if c.parityRedundancy < 1 || c.parityRedundancy > 100 {
+		c.log("error", "Error : --parity-redundancy must be between 1 and 100\n")
+		c.returnCode = 16 // Invalid parity redundancy
+		return
+	}
+
+	//-----------------------------------------------------------------------------------------
+	// Setup the auto digest policy
+	// The digest names become every digest the policy can select so they are all validated below
+	//-----------------------------------------------------------------------------------------
+	if len(c.digestNames) == 1 && c.digestNames[0] == "auto" {
+		c.Option_AutoDigest = true
+		if autoPolicyString == "" {
+			autoPolicyString = os.Getenv(env_name_prefix + "_AUTO_POLICY")
+		}
+		if autoPolicyString == "" {
+			autoPolicyString = defaultAutoPolicy
+		}
+		var err error
+		if c.autoPolicy, err = parseAutoPolicy(autoPolicyString); err != nil {
+			c.log("error", "Error : %s\n", err.Error())
+			c.returnCode = 14 // Invalid auto policy
+			return
+		}
+		c.digestNames = []string{}
+		for _, policyDigestNames := range c.autoPolicy {
+			for _, digestName := range policyDigestNames {
+				if !slices.Contains(c.digestNames, digestName) {
+					c.digestNames = append(c.digestNames, digestName)
+				}
+			}
+		}
+		c.log("debug", "c.autoPolicy: '%v'\n", c.autoPolicy)
+	}
+
+	// A snapshot records a single directory with a single digest
+	if c.snapshotPath != "" {
+		if getopt.NArgs() != 1 {
+			c.log("error", "Error : --snapshot takes a single directory\n")
+			c.returnCode = 17 // Invalid snapshot options
+			return
+		}
+		if c.Action == "add" && len(c.digestNames) != 1 {
+			c.log("error", "Error : --snapshot takes a single digest\n")
+			c.returnCode = 17 // Invalid snapshot options
+			return
+		}
+	}
+
+	if c.Option_Moves && getopt.NArgs() != 2 {
+		c.log("error", "Error : --moves takes an old and a new directory or snapshot\n")
+		c.returnCode = 18 // Invalid moves options
+		return
+	}
+
+	if c.Subcommand == "copy" && getopt.NArgs() < 2 {
+		c.log("error", "Error : copy takes one or more sources and a destination\n")
+		c.returnCode = 20 // Invalid copy options
+		return
+	}
+
+	if c.Subcommand == "tee" && getopt.NArgs() != 1 {
+		c.log("error", "Error : tee takes a single file to write stdin to\n")
+		c.returnCode = 27 // Invalid stdin options
+		return
+	}
+
+	// Data read from stdin can only be hashed by the digests which don't need a file
+	if c.Option_Stdin {
+		if getopt.NArgs() != 0 || c.Subcommand != "" {
+			c.log("error", "Error : --stdin doesn't take any paths\n")
+			c.returnCode = 27 // Invalid stdin options
+			return
+		}
+		if c.Option_AutoDigest {
+			c.log("error", "Error : --digest=auto needs a file to choose digests by file type, it can't be used with --stdin\n")
+			c.returnCode = 27 // Invalid stdin options
+			return
+		}
+		for _, digestName := range c.digestNames {
+			if _, isFileDigest := fileDigestTypes[digestName]; isFileDigest {
+				c.log("error", "Error : digest '%s' needs a file, it can't be used with --stdin\n", digestName)
+				c.returnCode = 27 // Invalid stdin options
+				return
+			}
+		}
+	}
+
+	if c.Option_CopyAttrs && getopt.NArgs() != 2 {
+		c.log("error", "Error : --copy-attrs takes a source and a destination\n")
+		c.returnCode = 21 // Invalid copy-attrs options
+		return
+	}
+
+	if (c.Option_Duplicates || c.Option_DedupeHardlink) && len(c.digestNames) != 1 {
+		c.log("error", "Error : --duplicates and --dedupe-hardlink take a single digest\n")
+		c.returnCode = 22 // Invalid duplicates options
+		return
+	}
+
+	if c.Option_Compare && getopt.NArgs() != 2 {
+		c.log("error", "Error : --compare takes a source and a destination directory\n")
+		c.returnCode = 19 // Invalid compare options
+		return
+	}
+
+	// Paths read from a file are processed in the same way as the paths given as arguments
+	if c.Option_Null && c.filesFromPath == "" {
+		c.log("error", "Error : --null only applies to --files-from\n")
+		c.returnCode = 26 // Invalid files-from options
+		return
+	}
+	if c.filesFromPath != "" && (c.snapshotPath != "" || c.Option_Moves || c.Subcommand != "" || c.Option_CopyAttrs || c.findChecksum != "" || c.Option_DedupeHardlink || c.Option_Duplicates || c.Option_Compare || c.Option_Stdin) {
+		c.log("error", "Error : --files-from can only be used with --add, --check, --list, --delete, --transform and --tree\n")
+		c.returnCode = 26 // Invalid files-from options
+		return
+	}
+
+	// Paths are shown relative to an absolute base directory, so they're correct whichever form the paths are given in
+	if c.relativeTo != "" {
+		if c.Option_ShortPaths {
+			c.log("error", "Error : --relative-to can't be used with --short-paths\n")
+			c.returnCode = 28 // Invalid relative-to options
+			return
+		}
+		relativeTo, err := filepath.Abs(c.relativeTo)
+		if err != nil {
+			c.log("error", "Error : %s for --relative-to\n", err.Error())
+			c.returnCode = 28 // Invalid relative-to options
+			return
+		}
+		c.relativeTo = relativeTo
+	}
+
+	// Check we know how to handle symlinks
+	if !slices.Contains(symlinkModes, c.symlinkMode) {
+		c.log("error", "Error : unknown symlink mode '%s'\n Should be one of: %s\n", c.symlinkMode, strings.Join(symlinkModes, ", "))
+		c.returnCode = 25 // Unknown symlink mode
+		return
 	}
 
 	// Check if the display format doesn't make the digest
@@ -328,7 +657,7 @@ func (c *Config) parseCmdlineOpt() {
 	// Check we know all the given digest names
 	//-----------------------------------------------------------------------------------------
 	for _, digestName := range c.digestNames {
-		if digestName != "oshash" && digestName != "phash" {
+		if _, isFileDigest := fileDigestTypes[digestName]; !isFileDigest {
 			if digest, exists := digestTypes[digestName]; exists {
 				c.digestList[digestName] = digest
 			} else {
@@ -0,0 +1,345 @@
+package integrity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/xattr"
+)
+
+// Errors returned by integ_copyFile
+var errCopyDestinationExists = errors.New("destination exists, use --force to overwrite")
+var errCopySourceCorrupt = errors.New("source does not match its stored checksum")
+var errCopyVerifyFailed = errors.New("destination does not match the data read from the source")
+
+// integ_copyFile copies a file, hashing the data as it is streamed and verifying the destination against it
+// The source's integrity attributes, parity data, permissions and modification time are copied
+// along with the data, and checksums are added for any of the digests the source didn't have stored.
+// The copy is written to a temporary file next to the destination, which only replaces any existing
+// file once it has been verified. Returns the checksum of the destination for each digest.
+func integ_copyFile(srcPath string, dstPath string, srcInfo os.FileInfo, digestNames []string) (map[string]string, error) {
+	if _, err := os.Lstat(dstPath); err == nil && !config.Option_Force {
+		return nil, errCopyDestinationExists
+	}
+	tmpFile, err := integ_createCopyTemp(dstPath)
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+
+	checksums, err := integ_copyData(srcPath, tmpPath, srcInfo, digestNames)
+	if err == nil {
+		err = integ_copyIntegrityData(srcPath, tmpPath)
+	}
+	if err == nil {
+		err = integ_verifyCopy(tmpPath, srcInfo, digestNames, checksums)
+	}
+	if err == nil {
+		err = os.Chtimes(tmpPath, srcInfo.ModTime(), srcInfo.ModTime())
+	}
+	if err != nil {
+		// Don't leave a copy behind which may look complete
+		integ_removeCopy(tmpPath)
+		return nil, err
+	}
+	if err = integ_renameCopy(tmpPath, dstPath); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
+
+// integ_createCopyTemp creates a hidden temporary file next to dstPath for a copy to be written to and verified in,
+// keeping dstPath's extension so the file type is still recognised
+func integ_createCopyTemp(dstPath string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".integrity-copy.*"+filepath.Ext(dstPath))
+}
+
+// integ_renameCopy moves a verified copy over dstPath, replacing any existing file
+// The copy is removed if it can't be moved into place, leaving any existing file untouched
+func integ_renameCopy(tmpPath string, dstPath string) error {
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		integ_removeCopy(tmpPath)
+		return err
+	}
+	if err := integ_renameSidecars(tmpPath, dstPath); err != nil {
+		// Don't leave a copy behind which may look complete
+		integ_removeCopy(tmpPath)
+		integ_removeCopy(dstPath)
+		return err
+	}
+	return nil
+}
+
+// integ_renameSidecars moves the sidecar files of a copy renamed to dstPath, replacing those of the file overwritten
+// Sidecar files are named after the file they belong to, and blockmaps refer to their sidecar by name
+func integ_renameSidecars(tmpPath string, dstPath string) error {
+	err := os.Rename(paritySidecarPath(tmpPath), paritySidecarPath(dstPath))
+	if os.IsNotExist(err) {
+		err = os.Remove(paritySidecarPath(dstPath))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	blockmapAttribute := config.xattribute_prefix + "blockmap"
+	value, err := xattr.Get(dstPath, blockmapAttribute)
+	if err != nil {
+		// No blockmap, so any sidecar is left over from the file overwritten
+		if err = os.Remove(blockmapSidecarPath(dstPath)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	// The copy's sidecar is in the same directory so is found from the new path
+	blockmapValue, err := blockmapResolveSidecar(dstPath, string(value))
+	if err != nil {
+		return err
+	}
+	storedValue, err := blockmapStoreValue(dstPath, blockmapValue)
+	if err != nil {
+		return err
+	}
+	if err = xattr.Set(dstPath, blockmapAttribute, []byte(storedValue)); err != nil {
+		return err
+	}
+	if err = os.Remove(blockmapSidecarPath(tmpPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// integ_removeCopy removes a copy which failed, along with any sidecar files written for it
+func integ_removeCopy(path string) {
+	os.Remove(path)
+	os.Remove(blockmapSidecarPath(path))
+	os.Remove(paritySidecarPath(path))
+}
+
+// integ_copyData copies the data of a file, returning the checksum of the data read for each crypto.Hash digest
+func integ_copyData(srcPath string, dstPath string, srcInfo os.FileInfo, digestNames []string) (map[string]string, error) {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcInfo.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+
+	// Hash the data as it's copied, file type digests need the whole file so are calculated after
+	checksums, err := integ_hashReader(srcFile, dstFile, digestNames)
+	if err == nil {
+		err = dstFile.Sync()
+	}
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(dstPath, srcInfo.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
+
+// integ_copyIntegrityData copies every integrity attribute, and any sidecar files, from one file to another
+func integ_copyIntegrityData(srcPath string, dstPath string) error {
+	attributeNames, err := xattr.List(srcPath)
+	if err != nil {
+		return err
+	}
+	for _, attributeName := range attributeNames {
+		if !strings.HasPrefix(attributeName, config.xattribute_prefix) {
+			continue
+		}
+		value, err := xattr.Get(srcPath, attributeName)
+		if err != nil {
+			return err
+		}
+		if attributeName == config.xattribute_prefix+"blockmap" {
+			// Sidecar references are by name so are rewritten for the destination's sidecar
+			var blockmapValue string
+			if blockmapValue, err = blockmapResolveSidecar(srcPath, string(value)); err != nil {
+				return err
+			}
+			var storedValue string
+			if storedValue, err = blockmapStoreValue(dstPath, blockmapValue); err != nil {
+				return err
+			}
+			value = []byte(storedValue)
+		}
+		if err = xattr.Set(dstPath, attributeName, value); err != nil {
+			return err
+		}
+	}
+
+	parityData, err := os.ReadFile(paritySidecarPath(srcPath))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return os.WriteFile(paritySidecarPath(dstPath), parityData, 0644)
+}
+
+// integ_verifyCopy re-reads a copied file and checks it against the data read from the source and
+// the source's stored checksums, storing checksums for any digests the source didn't have
+func integ_verifyCopy(dstPath string, srcInfo os.FileInfo, digestNames []string, checksums map[string]string) error {
+	var dstCard integrity_fileCard
+	dstCard.FileInfo = &srcInfo
+	dstCard.fullpath = dstPath
+	for _, digestName := range digestNames {
+		config.DigestName = digestName
+		config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+		config.log("debug", "copy: '%s'\n", config.xattribute_fullname)
+
+		streamedChecksum, wasStreamed := checksums[digestName]
+		storedChecksum, err := integ_getChecksumRaw(dstPath)
+		if err == nil {
+			// The source's checksum was copied, so check the data read from the source and the destination against it
+			if wasStreamed && streamedChecksum != storedChecksum {
+				return errCopySourceCorrupt
+			}
+			if err = integ_checkChecksum(&dstCard); err != nil {
+				return fmt.Errorf("%w : %s", errCopyVerifyFailed, err.Error())
+			}
+		} else if !strings.Contains(err.Error(), "attribute not found") && !strings.Contains(err.Error(), "no data available") {
+			return err
+		} else if wasStreamed {
+			if err = integ_generateChecksum(&dstCard); err != nil {
+				return err
+			}
+			if dstCard.checksum != streamedChecksum {
+				return errCopyVerifyFailed
+			}
+			if err = xattr.Set(dstPath, config.xattribute_fullname, []byte(streamedChecksum)); err != nil {
+				return err
+			}
+		} else if err = integ_writeChecksum(&dstCard); err != nil {
+			return err
+		}
+		checksums[digestName] = dstCard.checksum
+	}
+	return nil
+}
+
+// integ_handleCopy copies each source to the destination, recursing into directories with --recursive
+// With more than one source the destination must be a directory the sources are copied into
+func integ_handleCopy(srcPaths []string, dstPath string) {
+	dstInfo, err := os.Stat(dstPath)
+	dstIsDir := err == nil && dstInfo.IsDir()
+	if len(srcPaths) > 1 && !dstIsDir {
+		config.log("error", "Error : copying more than one source needs an existing destination directory\n")
+		config.returnCode = 20 // Invalid copy options
+		return
+	}
+
+	for _, srcPath := range srcPaths {
+		srcInfo, err := os.Stat(srcPath)
+		if err != nil {
+			displayFileErrorMessageNoDigest(srcPath, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 12 // Error stating file
+			continue
+		}
+		target := dstPath
+		if dstIsDir {
+			target = filepath.Join(dstPath, filepath.Base(srcPath))
+		}
+
+		if !srcInfo.IsDir() {
+			integ_copyPath(srcPath, target, srcInfo)
+			continue
+		}
+		if !config.Option_Recursive {
+			switch config.VerboseLevel {
+			case 0, 1:
+				// Don't print anything we're 'quiet' / this is not an error
+			case 2:
+				displayFileMessageNoDigest(srcPath, "skipping directory")
+			}
+			continue
+		}
+		err = integ_walk(srcPath, func(path string, fileinfo os.FileInfo, err error) error {
+			if err != nil {
+				return integ_walkError(path, fileinfo, err)
+			}
+			relativePath, err := filepath.Rel(srcPath, path)
+			if err != nil {
+				return err
+			}
+			if fileinfo.IsDir() {
+				return os.MkdirAll(filepath.Join(target, relativePath), fileinfo.Mode().Perm()|0700)
+			}
+			integ_copyPath(path, filepath.Join(target, relativePath), fileinfo)
+			return nil
+		})
+		if err != nil {
+			displayFileErrorMessageNoDigest(srcPath, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+		}
+	}
+}
+
+// integ_copyPath copies a single file and reports the result
+func integ_copyPath(srcPath string, dstPath string, srcInfo os.FileInfo) {
+	if integ_isSidecar(srcInfo.Name()) {
+		// Sidecar files are copied along with the file they belong to
+		return
+	}
+	if !srcInfo.Mode().IsRegular() {
+		switch config.VerboseLevel {
+		case 0, 1:
+			// Don't print anything we're 'quiet' / this is not an error
+		case 2:
+			displayFileMessageNoDigest(srcPath, "skipping, not a regular file")
+		}
+		return
+	}
+
+	digestNames := config.digestNames
+	if config.Option_AutoDigest {
+		var srcCard integrity_fileCard
+		srcCard.FileInfo = &srcInfo
+		srcCard.fullpath = srcPath
+		var err error
+		if digestNames, err = integ_autoDigestNames(&srcCard); err != nil {
+			displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("copy FAILED : Error detecting file type : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		}
+	}
+
+	checksums, err := integ_copyFile(srcPath, dstPath, srcInfo, digestNames)
+	if err != nil {
+		switch config.VerboseLevel {
+		case 0, 1:
+			// Always output errors even if we're 'quiet', leaving out the details of failed checks
+			message := err.Error()
+			if errors.Is(err, errCopyVerifyFailed) {
+				message = errCopyVerifyFailed.Error()
+			}
+			displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("copy FAILED : %s", message))
+		case 2:
+			displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("copy FAILED : %s", err.Error()))
+		}
+		config.returnCode = 13 // Error handling path
+		return
+	}
+	for _, digestName := range digestNames {
+		config.DigestName = digestName
+		switch config.VerboseLevel {
+		case 0:
+			// Don't print anything we're 'quiet'
+		case 1:
+			displayFileMessage(dstPath, "copied")
+		case 2:
+			displayFileMessage(dstPath, fmt.Sprintf("%s : copied and verified", checksums[digestName]))
+		}
+	}
+}
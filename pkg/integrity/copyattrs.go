@@ -0,0 +1,192 @@
+package integrity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/xattr"
+)
+
+// Error returned by integ_copyAttrs when the copy doesn't match the source's checksum
+var errTransferCorrupt = errors.New("TRANSFER CORRUPT")
+
+// integ_storedDigestNames returns the names of the known digests with a checksum stored for a file
+func integ_storedDigestNames(path string) ([]string, error) {
+	attributeNames, err := xattr.List(path)
+	if err != nil {
+		return nil, err
+	}
+	var digestNames []string
+	for _, attributeName := range attributeNames {
+		digestName, found := strings.CutPrefix(attributeName, config.xattribute_prefix)
+		if !found {
+			continue
+		}
+		_, isFileDigest := fileDigestTypes[digestName]
+		if _, isDigest := digestTypes[digestName]; isDigest || isFileDigest {
+			digestNames = append(digestNames, digestName)
+		}
+	}
+	sort.Strings(digestNames)
+	return digestNames, nil
+}
+
+// integ_copyAttrs checks a copy of a file against every checksum stored for the source, using the
+// same comparison as --check, and copies the source's integrity attributes to the copy if they all match
+// Returns the names of the digests checked, the current digest is left as the first digest which doesn't match
+func integ_copyAttrs(srcPath string, dstPath string) ([]string, error) {
+	digestNames, err := integ_storedDigestNames(srcPath)
+	if err != nil || len(digestNames) == 0 {
+		return digestNames, err
+	}
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return nil, err
+	}
+	var srcCard, dstCard integrity_fileCard
+	srcCard.fullpath = srcPath
+	dstCard.FileInfo = &dstInfo
+	dstCard.fullpath = dstPath
+
+	defaultBlockSize := config.blockSize
+	defer func() { config.blockSize = defaultBlockSize }()
+	for _, digestName := range digestNames {
+		if err = integ_useDigest(digestName); err != nil {
+			return nil, err
+		}
+		config.log("debug", "copy-attrs: '%s'\n", config.xattribute_fullname)
+		if digestName == "blockmap" {
+			// The copy's blockmap has to be calculated with the block size of the source's blockmap
+			storedValue, err := integ_getChecksumRaw(srcPath)
+			if err == nil {
+				storedValue, err = blockmapResolveSidecar(srcPath, storedValue)
+			}
+			var stored *blockmap
+			if err == nil {
+				stored, err = parseBlockmap(storedValue)
+			}
+			if err != nil {
+				return nil, err
+			}
+			config.blockSize = stored.blockSize
+		}
+		if err = integ_generateChecksum(&dstCard); err != nil {
+			return nil, err
+		}
+		// Confirm the copy's checksum against the checksum stored on the source
+		srcCard.checksum = dstCard.checksum
+		if err = integ_confirmChecksum(&srcCard, dstCard.checksum); err != nil {
+			return nil, fmt.Errorf("%w : %s", errTransferCorrupt, err.Error())
+		}
+	}
+	return digestNames, integ_copyIntegrityData(srcPath, dstPath)
+}
+
+// integ_handleCopyAttrs copies the integrity attributes of every file below a source directory to the file at the
+// same relative path below a destination directory, once the destination file has been verified against them
+func integ_handleCopyAttrs(srcPath string, dstPath string) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		displayFileErrorMessageNoDigest(srcPath, fmt.Sprintf("ERROR : %s", err.Error()))
+		config.returnCode = 12 // Error stating file
+		return
+	}
+	if !srcInfo.IsDir() {
+		if dstInfo, err := os.Stat(dstPath); err == nil && dstInfo.IsDir() {
+			dstPath = filepath.Join(dstPath, filepath.Base(srcPath))
+		}
+		integ_copyAttrsPath(srcPath, dstPath)
+		return
+	}
+
+	err = integ_walk(srcPath, func(path string, fileinfo os.FileInfo, err error) error {
+		if err != nil {
+			return integ_walkError(path, fileinfo, err)
+		}
+		if !fileinfo.Mode().IsRegular() || integ_isSidecar(fileinfo.Name()) {
+			return nil
+		}
+		relativePath, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+		integ_copyAttrsPath(path, filepath.Join(dstPath, relativePath))
+		return nil
+	})
+	if err != nil {
+		displayFileErrorMessageNoDigest(srcPath, fmt.Sprintf("ERROR : %s", err.Error()))
+		config.returnCode = 13 // Error handling path
+	}
+}
+
+// integ_copyAttrsPath copies the integrity attributes of a single file and reports the result
+func integ_copyAttrsPath(srcPath string, dstPath string) {
+	if _, err := os.Stat(dstPath); os.IsNotExist(err) {
+		// Always output errors even if we're 'quiet'
+		displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("MISSING : no copy of %s", srcPath))
+		config.returnCode = 13 // Error handling path
+		return
+	}
+	if !config.Option_Force {
+		if dstDigestNames, err := integ_storedDigestNames(dstPath); err == nil && len(dstDigestNames) > 0 {
+			switch config.VerboseLevel {
+			case 0:
+				// Don't print anything we're 'quiet'
+			case 1:
+				displayFileMessageNoDigest(dstPath, "skipped")
+			case 2:
+				displayFileMessageNoDigest(dstPath, "skipped : We already have checksums stored")
+			}
+			return
+		}
+	}
+
+	digestNames, err := integ_copyAttrs(srcPath, dstPath)
+	if err != nil {
+		if errors.Is(err, errTransferCorrupt) {
+			// The copy doesn't match the source's checksum, always output even if we're 'quiet'
+			switch config.VerboseLevel {
+			case 0, 1:
+				displayFileErrorMessage(dstPath, errTransferCorrupt.Error())
+			case 2:
+				displayFileErrorMessage(dstPath, err.Error())
+			}
+		} else {
+			switch config.VerboseLevel {
+			case 0, 1:
+				// Always output errors even if we're 'quiet'
+				displayFileErrorMessageNoDigest(dstPath, "FAILED")
+			case 2:
+				displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("FAILED : Error copying checksums : %s", err.Error()))
+			}
+		}
+		config.returnCode = 13 // Error handling path
+		return
+	}
+	if len(digestNames) == 0 {
+		switch config.VerboseLevel {
+		case 0:
+			// Don't print anything we're 'quiet'
+		case 1:
+			displayFileMessageNoDigest(dstPath, "no checksum")
+		case 2:
+			displayFileMessageNoDigest(dstPath, fmt.Sprintf("no checksum stored for %s, skipped", srcPath))
+		}
+		return
+	}
+	for _, digestName := range digestNames {
+		config.DigestName = digestName
+		switch config.VerboseLevel {
+		case 0:
+			// Don't print anything we're 'quiet'
+		case 1:
+			displayFileMessage(dstPath, "verified, checksum copied")
+		case 2:
+			displayFileMessage(dstPath, fmt.Sprintf("verified against %s, checksum copied", srcPath))
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package integrity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Suffix of the temporary hard link created next to a duplicate before it replaces the duplicate
+const dedupeLinkSuffix = ".integrity-link"
+
+// Errors returned by integ_hardlinkDuplicate
+var errDedupeNotIdentical = errors.New("contents differ from the checksum match")
+var errDedupeCrossDevice = errors.New("on a different filesystem")
+
+// integ_filesIdentical compares two files byte for byte
+func integ_filesIdentical(pathA string, pathB string) (bool, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	bufferA := make([]byte, fileBufferSize)
+	bufferB := make([]byte, fileBufferSize)
+	for {
+		readA, errA := io.ReadFull(fileA, bufferA)
+		readB, errB := io.ReadFull(fileB, bufferB)
+		if !bytes.Equal(bufferA[:readA], bufferB[:readB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		} else if errA != nil {
+			return false, errA
+		} else if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+	}
+}
+
+// integ_dedupeKeeper chooses the file of a duplicate group to keep, the one with the most integrity
+// attributes stored so the fewest checksums are lost, or the first path if they have the same number
+func integ_dedupeKeeper(paths []string) string {
+	keeper, keeperAttributes := paths[0], -1
+	for _, path := range paths {
+		digestNames, _ := integ_storedDigestNames(path)
+		if len(digestNames) > keeperAttributes {
+			keeper, keeperAttributes = path, len(digestNames)
+		}
+	}
+	return keeper
+}
+
+// integ_hardlinkDuplicate replaces a duplicate with a hard link to the file being kept, once the two have been
+// compared byte for byte. The link is created next to the duplicate first and renamed over it, so the duplicate
+// is never missing, and an error is returned without making any changes if the files are on different filesystems.
+func integ_hardlinkDuplicate(keeper string, duplicate string) error {
+	identical, err := integ_filesIdentical(keeper, duplicate)
+	if err != nil {
+		return err
+	}
+	if !identical {
+		return errDedupeNotIdentical
+	}
+	linkPath := filepath.Join(filepath.Dir(duplicate), "."+filepath.Base(duplicate)+dedupeLinkSuffix)
+	if err = os.Link(keeper, linkPath); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return errDedupeCrossDevice
+		}
+		return err
+	}
+	if err = os.Rename(linkPath, duplicate); err != nil {
+		os.Remove(linkPath)
+		return err
+	}
+	return nil
+}
+
+// integ_handleDedupe replaces duplicate files below the given paths with hard links to a single copy
+// With --dry-run the files which would be linked are reported without changing anything
+func integ_handleDedupe(roots []string) {
+	config.DigestName = config.digestNames[0]
+	config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+	groups, err := integ_findDuplicates(roots)
+	if err != nil {
+		config.log("error", "Error finding duplicates : %s\n", err.Error())
+		config.returnCode = 13 // Error handling path
+		return
+	}
+
+	linked := 0
+	var saved int64
+	for _, group := range groups {
+		keeper := integ_dedupeKeeper(group.Paths)
+		keeperInfo, err := os.Stat(keeper)
+		if err != nil {
+			displayFileErrorMessageNoDigest(keeper, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			continue
+		}
+		keeperDevice, keeperDeviceKnown := integ_deviceID(keeperInfo)
+		// Duplicates already hard linked to each other only free their space once, and only once every link has been replaced
+		var linkedInfos []os.FileInfo
+		var linkedCounts []uint64
+		for _, duplicate := range group.Paths {
+			if duplicate == keeper {
+				continue
+			}
+			duplicateInfo, err := os.Stat(duplicate)
+			if err == nil && os.SameFile(keeperInfo, duplicateInfo) {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Don't print anything we're 'quiet' / this is not an error
+				case 2:
+					displayFileMessageNoDigest(duplicate, fmt.Sprintf("already linked to %s", keeper))
+				}
+				continue
+			}
+			if err == nil && keeperDeviceKnown {
+				if device, known := integ_deviceID(duplicateInfo); known && device != keeperDevice {
+					switch config.VerboseLevel {
+					case 0, 1:
+						// Don't print anything we're 'quiet' / this is not an error
+					case 2:
+						displayFileMessageNoDigest(duplicate, fmt.Sprintf("skipped : %s to %s", errDedupeCrossDevice.Error(), keeper))
+					}
+					continue
+				}
+			}
+
+			if config.Option_DryRun {
+				// Still compare the files so the preview matches what would happen
+				identical, err := integ_filesIdentical(keeper, duplicate)
+				if err == nil && !identical {
+					err = errDedupeNotIdentical
+				}
+				if err != nil {
+					displayFileErrorMessageNoDigest(duplicate, fmt.Sprintf("skipped : %s", err.Error()))
+					continue
+				}
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1, 2:
+					displayFileMessageNoDigest(duplicate, fmt.Sprintf("would link to %s", keeper))
+				}
+			} else {
+				if err = integ_hardlinkDuplicate(keeper, duplicate); err != nil {
+					if errors.Is(err, errDedupeCrossDevice) {
+						switch config.VerboseLevel {
+						case 0, 1:
+							// Don't print anything we're 'quiet' / this is not an error
+						case 2:
+							displayFileMessageNoDigest(duplicate, fmt.Sprintf("skipped : %s to %s", err.Error(), keeper))
+						}
+					} else {
+						// Always output errors even if we're 'quiet'
+						displayFileErrorMessageNoDigest(duplicate, fmt.Sprintf("skipped : %s", err.Error()))
+					}
+					continue
+				}
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1, 2:
+					displayFileMessageNoDigest(duplicate, fmt.Sprintf("linked to %s", keeper))
+				}
+			}
+			linked++
+			if duplicateInfo == nil {
+				saved += group.Size
+				continue
+			}
+			sharesInode := false
+			for i, linkedInfo := range linkedInfos {
+				if os.SameFile(linkedInfo, duplicateInfo) {
+					linkedCounts[i]++
+					sharesInode = true
+					break
+				}
+			}
+			if !sharesInode {
+				linkedInfos = append(linkedInfos, duplicateInfo)
+				linkedCounts = append(linkedCounts, 1)
+			}
+		}
+		// A duplicate with links outside the paths walked keeps its data, so frees nothing
+		for i, linkedInfo := range linkedInfos {
+			if links, known := integ_linkCount(linkedInfo); !known || linkedCounts[i] >= links {
+				saved += group.Size
+			}
+		}
+	}
+
+	switch config.VerboseLevel {
+	case 0:
+		// Don't print anything we're 'quiet'
+	case 1, 2:
+		if config.Option_DryRun {
+			fmt.Printf("%d files would be linked, %d bytes would be saved\n", linked, saved)
+		} else {
+			fmt.Printf("%d files linked, %d bytes saved\n", linked, saved)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package integrity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// duplicateGroup is a set of files with the same size and checksum
+type duplicateGroup struct {
+	Checksum    string   `json:"checksum"`
+	Size        int64    `json:"size"`
+	Paths       []string `json:"paths"`
+	Reclaimable int64    `json:"reclaimable"` // bytes freed by keeping only one of the files
+}
+
+// duplicateReport is the JSON output of --duplicates
+type duplicateReport struct {
+	Digest      string           `json:"digest"`
+	Groups      []duplicateGroup `json:"groups"`
+	Reclaimable int64            `json:"reclaimable"`
+}
+
+// integ_findDuplicates finds groups of duplicate files below the given paths using the current digest
+// Files are grouped by size first so checksums are only needed, and only calculated when not
+// stored, for files which share their size with another file. Empty files are ignored.
+func integ_findDuplicates(roots []string) ([]duplicateGroup, error) {
+	filesBySize := make(map[int64][]string)
+	fileInfos := make(map[string]os.FileInfo)
+	for _, root := range roots {
+		err := integ_walk(root, func(path string, fileinfo os.FileInfo, err error) error {
+			if err != nil {
+				return integ_walkError(path, fileinfo, err)
+			}
+			if !fileinfo.Mode().IsRegular() || integ_isSidecar(fileinfo.Name()) || fileinfo.Size() == 0 {
+				return nil
+			}
+			if _, seen := fileInfos[path]; seen {
+				// The same path given twice, or below two of the roots
+				return nil
+			}
+			fileInfos[path] = fileinfo
+			filesBySize[fileinfo.Size()] = append(filesBySize[fileinfo.Size()], path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var groups []duplicateGroup
+	for size, paths := range filesBySize {
+		if len(paths) < 2 {
+			continue
+		}
+		pathsByChecksum := make(map[string][]string)
+		for _, path := range paths {
+			fileinfo := fileInfos[path]
+			var currentFile integrity_fileCard
+			currentFile.FileInfo = &fileinfo
+			currentFile.fullpath = path
+			checksum, err := integ_getOrGenerateChecksum(&currentFile)
+			if err != nil {
+				displayFileErrorMessage(path, fmt.Sprintf("skipped : %s", err.Error()))
+				continue
+			}
+			pathsByChecksum[checksum] = append(pathsByChecksum[checksum], path)
+		}
+		for checksum, checksumPaths := range pathsByChecksum {
+			if len(checksumPaths) < 2 {
+				continue
+			}
+			sort.Strings(checksumPaths)
+			group := duplicateGroup{Checksum: checksum, Size: size, Paths: checksumPaths}
+			// Files already linked to another file in the group don't take up any more space
+			var distinct []os.FileInfo
+			for _, path := range checksumPaths {
+				linked := false
+				for _, other := range distinct {
+					if os.SameFile(fileInfos[path], other) {
+						linked = true
+						break
+					}
+				}
+				if !linked {
+					distinct = append(distinct, fileInfos[path])
+				}
+			}
+			group.Reclaimable = size * int64(len(distinct)-1)
+			groups = append(groups, group)
+		}
+	}
+
+	// Show the groups freeing the most space first
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reclaimable != groups[j].Reclaimable {
+			return groups[i].Reclaimable > groups[j].Reclaimable
+		}
+		return groups[i].Checksum < groups[j].Checksum
+	})
+	return groups, nil
+}
+
+// integ_handleDuplicates reports the groups of duplicate files below the given paths
+func integ_handleDuplicates(roots []string) {
+	config.DigestName = config.digestNames[0]
+	config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+	groups, err := integ_findDuplicates(roots)
+	if err != nil {
+		config.log("error", "Error finding duplicates : %s\n", err.Error())
+		config.returnCode = 13 // Error handling path
+		return
+	}
+	report := duplicateReport{Digest: config.DigestName, Groups: groups}
+	duplicateFiles := 0
+	for _, group := range groups {
+		report.Reclaimable += group.Reclaimable
+		duplicateFiles += len(group.Paths)
+	}
+
+	if config.Option_JSON {
+		if report.Groups == nil {
+			report.Groups = []duplicateGroup{}
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			config.log("error", "Error : %s\n", err.Error())
+			config.returnCode = 13 // Error handling path
+			return
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	for i, group := range groups {
+		if i > 0 {
+			fmt.Println()
+		}
+		for _, path := range group.Paths {
+			switch config.VerboseLevel {
+			case 0, 1:
+				displayFileMessage(path, group.Checksum)
+			case 2:
+				displayFileMessage(path, fmt.Sprintf("%s : %d bytes", group.Checksum, group.Size))
+			}
+		}
+	}
+	switch config.VerboseLevel {
+	case 0:
+		// Don't print anything we're 'quiet'
+	case 1, 2:
+		if len(groups) > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%d duplicate groups, %d files, %d bytes reclaimable\n", len(groups), duplicateFiles, report.Reclaimable)
+	}
+}
@@ -0,0 +1,53 @@
+package integrity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// walkError is an error for a path which was skipped so the rest of the run could carry on
+type walkError struct {
+	path string
+	err  error
+}
+
+// integ_walkError reports and records an error for a path found while walking, so the walk can carry on past it
+// A directory which can't be read is skipped along with everything below it, any other path is skipped on its own
+func integ_walkError(path string, fileinfo os.FileInfo, err error) error {
+	config.log("debug", "walk error: '%s' '%s'\n", path, err)
+	switch config.VerboseLevel {
+	case 0, 1:
+		// Always output errors even if we're 'quiet'
+		displayFileErrorMessageNoDigest(path, "skipped")
+	case 2:
+		displayFileErrorMessageNoDigest(path, fmt.Sprintf("skipped : %s", err.Error()))
+	}
+	config.walkErrors = append(config.walkErrors, walkError{path: path, err: err})
+	if fileinfo != nil && fileinfo.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// integ_summariseErrors reports the paths skipped because of errors once the run has finished, like rsync,
+// so they aren't lost among the output of a long run, and sets the return code to show the run completed with errors
+func integ_summariseErrors() {
+	if len(config.walkErrors) == 0 {
+		return
+	}
+	switch config.VerboseLevel {
+	case 0:
+		// Don't print anything we're 'quiet', each error has already been output
+	case 1:
+		fmt.Fprintf(os.Stderr, "completed with errors : %d skipped\n", len(config.walkErrors))
+	case 2:
+		fmt.Fprintf(os.Stderr, "completed with errors : %d skipped\n", len(config.walkErrors))
+		for _, walkErr := range config.walkErrors {
+			displayFileErrorMessageNoDigest(walkErr.path, walkErr.err.Error())
+		}
+	}
+	if config.returnCode == 0 {
+		config.returnCode = 11 // Completed with errors
+	}
+}
@@ -0,0 +1,42 @@
+package integrity
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// integ_runFilesFrom processes each path listed in a file, or stdin if the file is '-'
+// Paths are separated by new lines, or by NUL characters with --null so any file name can be given.
+// The paths are read one at a time so lists of any length can be processed.
+func integ_runFilesFrom(listPath string) error {
+	var list io.Reader = os.Stdin
+	if listPath != "-" {
+		listFile, err := os.Open(listPath)
+		if err != nil {
+			return err
+		}
+		defer listFile.Close()
+		list = listFile
+	}
+
+	var separator byte = '\n'
+	if config.Option_Null {
+		separator = 0
+	}
+	reader := bufio.NewReader(list)
+	for {
+		path, err := reader.ReadString(separator)
+		if len(path) > 0 && path[len(path)-1] == separator {
+			path = path[:len(path)-1]
+		}
+		if path != "" {
+			integ_runPath(path)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
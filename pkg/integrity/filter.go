@@ -0,0 +1,216 @@
+package integrity
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Name of the file holding ignore patterns for a directory and everything below it
+const ignoreFileName = ".integrityignore"
+
+// Metadata files and directories created by macOS, Windows and filesystems themselves, skipped by --skip-system-files
+// Names are lower case as Windows names are case insensitive
+var systemFileNames = map[string]bool{
+	".ds_store":                 true,
+	".spotlight-v100":           true,
+	".trashes":                  true,
+	".fseventsd":                true,
+	".temporaryitems":           true,
+	".documentrevisions-v100":   true,
+	".apdisk":                   true,
+	"thumbs.db":                 true,
+	"desktop.ini":               true,
+	"$recycle.bin":              true,
+	"system volume information": true,
+	"lost+found":                true,
+}
+
+// isSystemFile reports whether a file name is a system metadata file, including macOS AppleDouble '._' files
+func isSystemFile(name string) bool {
+	return systemFileNames[strings.ToLower(name)] || strings.HasPrefix(name, "._")
+}
+
+// ignoreRule is a single gitignore style pattern
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool // a '!' pattern re-including paths excluded by an earlier pattern
+	dirOnly bool // a pattern ending in '/' only matches directories
+}
+
+// ignoreRules is an ordered list of patterns, matched against paths relative to the directory the patterns apply to
+type ignoreRules []ignoreRule
+
+// ignorePatternRegexp converts a gitignore style glob to a regular expression matching a relative path
+// Patterns containing a '/' are anchored to the directory the patterns apply to, others match at any depth.
+// '*' and '?' don't match '/', while '**' matches across directories.
+func ignorePatternRegexp(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var expression strings.Builder
+	expression.WriteString("^")
+	if !anchored {
+		expression.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				expression.WriteString("(?:.*/)?")
+				i += 2
+			} else if pattern[i:] == "**" {
+				expression.WriteString(".*")
+				i++
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern '%s' : unterminated [", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expression.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
+}
+
+// parseIgnoreRules parses lines of gitignore style patterns, skipping blank lines and '#' comments
+func parseIgnoreRules(lines []string) (ignoreRules, error) {
+	var rules ignoreRules
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		var err error
+		if rule.pattern, err = ignorePatternRegexp(line); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// readIgnoreFile reads the patterns from an ignore file, returning no patterns if the file doesn't exist
+func readIgnoreFile(ignorePath string) (ignoreRules, error) {
+	f, err := os.Open(ignorePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseIgnoreRules(lines)
+}
+
+// apply updates whether a path is excluded using the last of the rules matching it
+func (rules ignoreRules) apply(relativePath string, isDir bool, excluded bool) bool {
+	relativePath = filepath.ToSlash(relativePath)
+	for _, rule := range rules {
+		if (!rule.dirOnly || isDir) && rule.pattern.MatchString(relativePath) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// walkFilter decides which paths below a walk's root are excluded
+// using --exclude, --exclude-from and --include along with any .integrityignore files found
+type walkFilter struct {
+	root        string
+	device      uint64                 // filesystem the root is on, for --one-file-system
+	ignoreFiles map[string]ignoreRules // patterns from the ignore file in each directory walked
+}
+
+// loadIgnoreFile reads the ignore file in a directory being walked
+func (filter *walkFilter) loadIgnoreFile(dirPath string) error {
+	rules, err := readIgnoreFile(filepath.Join(dirPath, ignoreFileName))
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		filter.ignoreFiles[filepath.Clean(dirPath)] = rules
+	}
+	return nil
+}
+
+// excluded returns why a path below the walk's root is excluded, or an empty string if it isn't
+// System and hidden files are checked first, then the patterns given on the command line, then
+// the ignore files from the root down to the path's own directory, so deeper ignore files can override them
+func (filter *walkFilter) excluded(path string, fileinfo os.FileInfo) string {
+	if config.Option_SkipSystemFiles && isSystemFile(fileinfo.Name()) {
+		return "system file"
+	}
+	if config.Option_SkipHidden && strings.HasPrefix(fileinfo.Name(), ".") {
+		return "hidden path"
+	}
+	relativePath, err := filepath.Rel(filter.root, path)
+	if err != nil {
+		return ""
+	}
+	isDir := fileinfo.IsDir()
+	excluded := config.excludeRules.apply(relativePath, isDir, false)
+
+	var ancestors []string
+	for dirPath := filepath.Dir(path); ; dirPath = filepath.Dir(dirPath) {
+		ancestors = append(ancestors, dirPath)
+		if dirPath == filter.root || dirPath == filepath.Dir(dirPath) {
+			break
+		}
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if rules, exists := filter.ignoreFiles[ancestors[i]]; exists {
+			if dirRelativePath, err := filepath.Rel(ancestors[i], path); err == nil {
+				excluded = rules.apply(dirRelativePath, isDir, excluded)
+			}
+		}
+	}
+
+	// Directories are always descended so the files within them can be included
+	if !excluded && !isDir && len(config.includeRules) > 0 {
+		excluded = !config.includeRules.apply(relativePath, false, false)
+	}
+	if excluded {
+		return "excluded path"
+	}
+	return ""
+}
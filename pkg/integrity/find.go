@@ -0,0 +1,105 @@
+package integrity
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// findMatch is a checksum of a file matching the checksum searched for
+type findMatch struct {
+	digestName string
+	checksum   string
+}
+
+// integ_findMatches returns the checksums of a file whose checksum starts with the given checksum prefix
+// Stored checksums are searched for every digest, or just the digests asked for with --digest.
+// Files without a stored checksum are only hashed with --hash-missing.
+func integ_findMatches(currentFile *integrity_fileCard, checksumPrefix string) ([]findMatch, error) {
+	digestNames := config.digestNames
+	if !config.digestGiven {
+		var err error
+		if digestNames, err = integ_storedDigestNames(currentFile.fullpath); err != nil {
+			return nil, err
+		}
+		if len(digestNames) == 0 && config.Option_HashMissing {
+			digestNames = config.digestNames
+		}
+	}
+
+	var matches []findMatch
+	for _, digestName := range digestNames {
+		if err := integ_useDigest(digestName); err != nil {
+			return nil, err
+		}
+		haveDigestStored, err := integ_testChecksumStored(currentFile)
+		if err != nil {
+			return nil, err
+		}
+		if haveDigestStored {
+			err = integ_getChecksum(currentFile)
+		} else if config.Option_HashMissing {
+			err = integ_generateChecksum(currentFile)
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(strings.ToLower(currentFile.checksum), checksumPrefix) {
+			matches = append(matches, findMatch{digestName: digestName, checksum: currentFile.checksum})
+		}
+	}
+	return matches, nil
+}
+
+// integ_handleFind prints the files below the given paths with a checksum starting with config.findChecksum
+func integ_handleFind(roots []string) {
+	checksumPrefix := strings.ToLower(config.findChecksum)
+	found := 0
+	for _, root := range roots {
+		err := integ_walk(root, func(path string, fileinfo os.FileInfo, err error) error {
+			if err != nil {
+				return integ_walkError(path, fileinfo, err)
+			}
+			if !fileinfo.Mode().IsRegular() || integ_isSidecar(fileinfo.Name()) {
+				return nil
+			}
+			if config.findSize >= 0 && fileinfo.Size() != config.findSize {
+				return nil
+			}
+			var currentFile integrity_fileCard
+			currentFile.FileInfo = &fileinfo
+			currentFile.fullpath = path
+			matches, err := integ_findMatches(&currentFile, checksumPrefix)
+			if err != nil {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Always output errors even if we're 'quiet'
+					displayFileErrorMessageNoDigest(path, "skipped")
+				case 2:
+					displayFileErrorMessageNoDigest(path, fmt.Sprintf("skipped : %s", err.Error()))
+				}
+				return nil
+			}
+			for _, match := range matches {
+				// The matches are the result, so are output even if we're 'quiet'
+				config.DigestName = match.digestName
+				displayFileMessage(integ_generatefileDisplayPath(&currentFile), match.checksum)
+				found++
+			}
+			return nil
+		})
+		if err != nil {
+			config.log("error", "Error walking the path : %v : %v\n", root, err)
+			config.returnCode = 13 // Error handling path
+		}
+	}
+
+	switch config.VerboseLevel {
+	case 0, 1:
+		// Only the matching files are output
+	case 2:
+		fmt.Printf("%d files found\n", found)
+	}
+}
@@ -0,0 +1,33 @@
+package integrity
+
+import (
+	"fmt"
+)
+
+// inodeKey identifies a file by its device and inode, shared by all of the file's hard links
+type inodeKey struct {
+	device uint64
+	inode  uint64
+}
+
+// hardlinkRecord is the result of handling the first path seen for a file with several hard links
+type hardlinkRecord struct {
+	path   string
+	failed bool
+}
+
+// integ_reportHardlink reports a file as a hard link of a path already handled in this run
+// Extended attributes are stored on the inode, so every link shares the first path's checksums and result
+func integ_reportHardlink(fileDisplayPath string, first hardlinkRecord) {
+	if first.failed {
+		// Always output errors even if we're 'quiet'
+		displayFileErrorMessageNoDigest(fileDisplayPath, fmt.Sprintf("FAILED : hardlink of %s", first.path))
+		return
+	}
+	switch config.VerboseLevel {
+	case 0:
+		// Don't print anything we're 'quiet'
+	case 1, 2:
+		displayFileMessageNoDigest(fileDisplayPath, fmt.Sprintf("hardlink of %s", first.path))
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package integrity
+
+import (
+	"os"
+)
+
+// integ_inodeKey always reports files as having a single link where inodes aren't available
+func integ_inodeKey(fileinfo os.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+}
+
+// integ_deviceID never knows the filesystem a file is on where devices aren't available
+func integ_deviceID(fileinfo os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// integ_linkCount never knows how many links a file has where inodes aren't available
+func integ_linkCount(fileinfo os.FileInfo) (uint64, bool) {
+	return 0, false
+}
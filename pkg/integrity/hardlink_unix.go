@@ -0,0 +1,35 @@
+//go:build unix
+
+package integrity
+
+import (
+	"os"
+	"syscall"
+)
+
+// integ_inodeKey returns the device and inode of a file, and whether the file has more than one hard link
+func integ_inodeKey(fileinfo os.FileInfo) (inodeKey, bool) {
+	stat, ok := fileinfo.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return inodeKey{}, false
+	}
+	return inodeKey{device: uint64(stat.Dev), inode: uint64(stat.Ino)}, true
+}
+
+// integ_deviceID returns the device of the filesystem a file is on
+func integ_deviceID(fileinfo os.FileInfo) (uint64, bool) {
+	stat, ok := fileinfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
+
+// integ_linkCount returns the number of hard links to a file
+func integ_linkCount(fileinfo os.FileInfo) (uint64, bool) {
+	stat, ok := fileinfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Nlink), true
+}
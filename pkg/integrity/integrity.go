@@ -26,13 +26,12 @@ type integrity_fileCard struct {
 	fullpath    string
 	checksum    string
 	digest_name string
+	failed      bool // an error or failed check was reported for the file
 }
 
 // Buffer size for reading from file to show progress
 const fileBufferSize = 1024 * 1024 // 1MB
 
-// ToDo Add option to skip mac files http://www.westwind.com/reference/OS-X/invisibles.html
-// ToDo change errors to summarise at end like rsync - some errors occurred
 // ToDo check all errors goto stderr all normal messages go to stdout
 
 // Global config structure used througout the code
@@ -40,7 +39,7 @@ var config *Config = nil
 
 func integ_testChecksumStored(currentFile *integrity_fileCard) (bool, error) {
 	var err error
-	if _, err = xattr.Get(currentFile.fullpath, config.xattribute_fullname); err != nil {
+	if _, err = integ_xattrGet(currentFile.fullpath, config.xattribute_fullname); err != nil {
 		var errorString string = err.Error()
 		if strings.Contains(errorString, "attribute not found") || strings.Contains(errorString, "no data available") {
 			// We got an error with attribute not found (darwin) or no data available (linux) so simply return false and no error
@@ -113,7 +112,7 @@ func integ_swapXattrib(currentFile *integrity_fileCard) error {
 func integ_getChecksumRaw(path string) (string, error) {
 	var err error
 	var data []byte
-	if data, err = xattr.Get(path, config.xattribute_fullname); err != nil {
+	if data, err = integ_xattrGet(path, config.xattribute_fullname); err != nil {
 		return "", err
 	}
 	return string(data), nil
@@ -135,7 +134,15 @@ func integ_getChecksum(currentFile *integrity_fileCard) error {
 // this allows the outer code to determine if we actually removed an attribute or not
 func integ_removeChecksum(currentFile *integrity_fileCard) (bool, error) {
 	var err error
-	if err = xattr.Remove(currentFile.fullpath, config.xattribute_fullname); err != nil {
+	if config.DigestName == "blockmap" {
+		// Remove any sidecar file holding the blockmap along with the attribute
+		if storedValue, err := integ_getChecksumRaw(currentFile.fullpath); err == nil {
+			if err = blockmapRemoveSidecar(currentFile.fullpath, storedValue); err != nil {
+				return false, err
+			}
+		}
+	}
+	if err = integ_xattrRemove(currentFile.fullpath, config.xattribute_fullname); err != nil {
 		var errorString string = err.Error()
 		if strings.Contains(errorString, "attribute not found") || strings.Contains(errorString, "no data available") {
 			// We got an error with attribute not found so simply return false and no error
@@ -152,6 +159,12 @@ func integ_removeChecksum(currentFile *integrity_fileCard) (bool, error) {
 func integ_generateChecksum(currentFile *integrity_fileCard) error {
 	var err error
 
+	if integ_recordsLink(currentFile.fullpath) {
+		// A recorded symlink's checksum covers where it points, not the file it points to
+		currentFile.checksum, err = integ_linkChecksum(currentFile.fullpath)
+		return err
+	}
+
 	fileHandle, err := os.Open(currentFile.fullpath)
 	if err != nil {
 		return err
@@ -166,13 +179,8 @@ func integ_generateChecksum(currentFile *integrity_fileCard) error {
 
 	config.log("debug", "integ_generateChecksum config.DigestName:%s\n", config.DigestName)
 
-	if config.DigestName == "oshash" {
-		currentFile.checksum, err = oshashFromFilePath(currentFile.fullpath)
-		if err != nil {
-			return err
-		}
-	} else if config.DigestName == "phash" {
-		currentFile.checksum, err = integrityPhashFromFile(currentFile.fullpath)
+	if fileDigestFunc, isFileDigest := fileDigestTypes[config.DigestName]; isFileDigest {
+		currentFile.checksum, err = fileDigestFunc(currentFile.fullpath)
 		if err != nil {
 			return err
 		}
@@ -255,7 +263,15 @@ func integ_writeChecksum(currentFile *integrity_fileCard) error {
 		return err
 	}
 	checksumBytes := []byte(currentFile.checksum)
-	if err = xattr.Set(currentFile.fullpath, config.xattribute_fullname, checksumBytes); err != nil {
+	if config.DigestName == "blockmap" {
+		// Large blockmaps are stored in a sidecar file
+		var storedValue string
+		if storedValue, err = blockmapStoreValue(currentFile.fullpath, currentFile.checksum); err != nil {
+			return err
+		}
+		checksumBytes = []byte(storedValue)
+	}
+	if err = integ_xattrSet(currentFile.fullpath, config.xattribute_fullname, checksumBytes); err != nil {
 		return err
 	}
 	return nil
@@ -267,7 +283,12 @@ func integ_confirmChecksum(currentFile *integrity_fileCard, testChecksum string)
 	if xtattrbChecksum, err = integ_getChecksumRaw(currentFile.fullpath); err != nil {
 		return err
 	}
-	if testChecksum != xtattrbChecksum {
+	if config.DigestName == "blockmap" {
+		// Blockmaps report which byte ranges differ
+		if err = blockmapConfirm(currentFile.fullpath, xtattrbChecksum, testChecksum); err != nil {
+			return err
+		}
+	} else if testChecksum != xtattrbChecksum {
 		return fmt.Errorf("calculated checksum and filesystem read checksum differ!\n ├── stored [%s]\n └── calc'd [%s]", xtattrbChecksum, currentFile.checksum)
 	}
 	currentFile.digest_name = config.DigestName
@@ -321,7 +342,7 @@ func integ_printChecksum(currentFile *integrity_fileCard, fileDisplayPath string
 }
 
 func displayFileMessageNoDigest(fileDisplayPath string, message string) {
-	fmt.Printf("%s : %s\n", fileDisplayPath, message)
+	fmt.Printf("%s : %s%s", fileDisplayPath, message, integ_recordTerminator())
 }
 
 func displayFileErrorMessageNoDigest(fileDisplayPath string, message string) {
@@ -330,13 +351,16 @@ func displayFileErrorMessageNoDigest(fileDisplayPath string, message string) {
 
 func displayFileMessage(fileDisplayPath string, message string) {
 	if config.DisplayFormat == "sha1sum" && strings.HasPrefix(config.DigestName, "sha") {
-		fmt.Printf("%s *%s\n", message, fileDisplayPath)
+		escape, fileDisplayPath := integ_escapeSumPath(fileDisplayPath)
+		fmt.Printf("%s%s *%s%s", escape, message, fileDisplayPath, integ_recordTerminator())
 	} else if config.DisplayFormat == "md5sum" && strings.HasPrefix(config.DigestName, "md5") {
-		fmt.Printf("%s  %s\n", message, fileDisplayPath)
+		escape, fileDisplayPath := integ_escapeSumPath(fileDisplayPath)
+		fmt.Printf("%s%s  %s%s", escape, message, fileDisplayPath, integ_recordTerminator())
 	} else if config.DisplayFormat == "cksum" {
-		fmt.Printf("%s (%s) = %s\n", config.DigestName, fileDisplayPath, message)
+		escape, fileDisplayPath := integ_escapeSumPath(fileDisplayPath)
+		fmt.Printf("%s%s (%s) = %s%s", escape, config.DigestName, fileDisplayPath, message, integ_recordTerminator())
 	} else {
-		fmt.Printf("%s : %s : %s\n", fileDisplayPath, config.DigestName, message)
+		fmt.Printf("%s : %s : %s%s", fileDisplayPath, config.DigestName, message, integ_recordTerminator())
 	}
 }
 
@@ -344,15 +368,62 @@ func displayFileErrorMessage(fileDisplayPath string, message string) {
 	fmt.Fprintf(os.Stderr, "%s : %s : %s\n", fileDisplayPath, config.DigestName, message)
 }
 
+// integ_recordTerminator returns the character ending each line of output, a NUL with --zero so any path can be parsed
+func integ_recordTerminator() string {
+	if config.Option_Zero {
+		return "\x00"
+	}
+	return "\n"
+}
+
+// Escapes used by coreutils' sum tools for file names which would otherwise break a line of output
+var sumPathEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// integ_escapeSumPath escapes a path for the sum formats in the same way as coreutils, so the output can be read by
+// sha1sum -c. Paths containing a backslash, new line or carriage return are escaped and the line starts with a '\'.
+// Nothing is escaped with --zero as coreutils' -z output doesn't escape either.
+func integ_escapeSumPath(path string) (string, string) {
+	if config.Option_Zero || !strings.ContainsAny(path, "\\\n\r") {
+		return "", path
+	}
+	return `\`, sumPathEscaper.Replace(path)
+}
+
 func integ_generatefileDisplayPath(currentFile *integrity_fileCard) string {
 	if config.Option_ShortPaths {
 		fileInfo := *currentFile.FileInfo
 		return fileInfo.Name()
+	} else if config.relativeTo != "" {
+		// Fall back to the path as given if it can't be made relative
+		if absPath, err := filepath.Abs(currentFile.fullpath); err == nil {
+			if relativePath, err := filepath.Rel(config.relativeTo, absPath); err == nil {
+				return relativePath
+			}
+		}
+		return currentFile.fullpath
 	} else {
 		return currentFile.fullpath
 	}
 }
 
+// Infixes of the hidden temporary files integrity creates next to a file, which may be left behind by a crash
+var integ_tempInfixes = []string{paritySidecarSuffix + ".", ".integrity-repair.", ".integrity-copy.", ".integrity-tee."}
+
+// integ_isSidecar reports whether a file name is one of the sidecar or temporary files integrity creates
+func integ_isSidecar(name string) bool {
+	if strings.HasSuffix(name, blockmapSidecarSuffix) || strings.HasSuffix(name, paritySidecarSuffix) {
+		return true
+	}
+	if strings.HasPrefix(name, ".") {
+		for _, infix := range integ_tempInfixes {
+			if strings.Contains(name, infix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func Run() int {
 	config = newConfig()
 
@@ -369,76 +440,161 @@ func Run() int {
 		return config.returnCode
 	}
 
+	integ_runPaths()
+	integ_summariseErrors()
+
+	config.log("debug", "config.returnCode: %d\n", config.returnCode)
+	return config.returnCode
+}
+
+// integ_runPaths carries out the action asked for on the paths given on the command line
+func integ_runPaths() {
+	if config.Option_Moves {
+		integ_handleMoves(getopt.Arg(0), getopt.Arg(1))
+		return
+	}
+	if config.Subcommand == "copy" {
+		args := getopt.Args()
+		integ_handleCopy(args[:len(args)-1], args[len(args)-1])
+		return
+	}
+	if config.Subcommand == "tee" {
+		integ_handleTee(getopt.Arg(0))
+		return
+	}
+	if config.Option_Stdin {
+		integ_handleStdin()
+		return
+	}
+	if config.Option_CopyAttrs {
+		integ_handleCopyAttrs(getopt.Arg(0), getopt.Arg(1))
+		return
+	}
+	if config.findChecksum != "" {
+		integ_handleFind(getopt.Args())
+		return
+	}
+	if config.Option_DedupeHardlink {
+		integ_handleDedupe(getopt.Args())
+		return
+	}
+	if config.Option_Duplicates {
+		integ_handleDuplicates(getopt.Args())
+		return
+	}
+	if config.Option_Compare {
+		integ_handleCompare(getopt.Arg(0), getopt.Arg(1))
+		return
+	}
+
 	for _, path := range getopt.Args() {
-		// ToDo: Consider how to deal with symlinks, should be follow them?
-		config.log("debug", "path: '%s'\n", path)
-		path_fileinfo, err := os.Stat(path)
-		// If we can stat the given file
-		if err != nil {
-			errorString := err.Error()
-			if strings.Contains(errorString, "no such file or directory") {
-				config.log("error", "%s : no such file or directory\n", path)
-				config.returnCode = 10 // No such file or directory
-				continue
-			}
-			displayFileErrorMessageNoDigest(path, fmt.Sprintf("ERROR : %s", err.Error()))
-			config.returnCode = 12 // Error stating file
-			continue
+		integ_runPath(path)
+	}
+	if config.filesFromPath != "" {
+		if err := integ_runFilesFrom(config.filesFromPath); err != nil {
+			config.log("error", "Error : %s reading --files-from\n", err.Error())
+			config.returnCode = 26 // Invalid files-from options
 		}
+	}
+}
 
-		if path_fileinfo.IsDir() {
-			config.log("debug", "path is directory: recurse? '%t'\n", config.Option_Recursive)
-			if config.Option_Recursive {
-				// Walk the directory structure
-				err := filepath.Walk(path, handle_path)
-				if err != nil {
-					config.log("debug", "Error from filepath.Walk: err(%s)", err.Error())
-					return 1
-				}
-			} else {
-				switch config.VerboseLevel {
-				case 0, 1:
-					// Don't print anything we're 'quiet' / this is not an error
-				case 2:
-					displayFileMessageNoDigest(path, "skipping directory")
-				}
+// integ_runPath carries out the action asked for on a single path given on the command line or with --files-from
+func integ_runPath(path string) {
+	config.log("debug", "path: '%s'\n", path)
+	// Symlinks given on the command line are followed, unless they're being recorded
+	statFunc := os.Stat
+	if config.symlinkMode == "record" {
+		statFunc = os.Lstat
+	}
+	path_fileinfo, err := statFunc(path)
+	// If we can stat the given file
+	if err != nil {
+		errorString := err.Error()
+		if strings.Contains(errorString, "no such file or directory") {
+			config.log("error", "%s : no such file or directory\n", path)
+			config.returnCode = 10 // No such file or directory
+			return
+		}
+		displayFileErrorMessageNoDigest(path, fmt.Sprintf("ERROR : %s", err.Error()))
+		config.returnCode = 12 // Error stating file
+		return
+	}
+
+	if config.snapshotPath != "" {
+		if !path_fileinfo.IsDir() {
+			config.log("error", "Error : --snapshot takes a single directory\n")
+			config.returnCode = 17 // Invalid snapshot options
+			return
+		}
+		integ_handleSnapshot(path)
+	} else if path_fileinfo.IsDir() {
+		config.log("debug", "path is directory: recurse? '%t'\n", config.Option_Recursive)
+		if config.Option_Tree {
+			// Tree checksums always cover the whole directory structure
+			integ_handleTree(path)
+		} else if config.Option_Recursive {
+			// Walk the directory structure
+			if err := integ_walk(path, handle_path); err != nil {
+				integ_walkError(path, path_fileinfo, err)
 			}
 		} else {
-			if err = handle_path(path, path_fileinfo, err); err != nil {
-				displayFileErrorMessageNoDigest(path, fmt.Sprintf("ERROR : %s", err.Error()))
-				config.returnCode = 13 // Error handling path
-				continue
+			switch config.VerboseLevel {
+			case 0, 1:
+				// Don't print anything we're 'quiet' / this is not an error
+			case 2:
+				displayFileMessageNoDigest(path, "skipping directory")
 			}
 		}
+	} else {
+		if err = handle_path(path, path_fileinfo, err); err != nil {
+			displayFileErrorMessageNoDigest(path, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+		}
 	}
-
-	config.log("debug", "config.returnCode: %d\n", config.returnCode)
-	return config.returnCode
 }
 
 func handle_path(path string, fileinfo os.FileInfo, err error) error {
 	config.log("debug", "handle_path: '%s'\n", path)
 	if err != nil {
 		config.log("debug", "handle_path: error '%s'\n", err)
-		if strings.Contains(err.Error(), "permission denied") {
-			switch config.VerboseLevel {
-			case 0, 1:
-				// Always output errors even if we're 'quiet'
-				displayFileErrorMessageNoDigest(path, "skipped")
-			case 2:
-				displayFileErrorMessageNoDigest(path, fmt.Sprintf("skipped : %s", err.Error()))
-			}
-			return filepath.SkipDir
-		} else {
-			// Handle the error and return it to stop walking
-			config.log("error", "Error walking the path : %v : %v\n", path, err)
-			return err
-		}
+		return integ_walkError(path, fileinfo, err)
 	}
 
 	config.log("debug", "no errors continuing\n")
 
+	if integ_isSidecar(fileinfo.Name()) {
+		// Don't checksum the sidecar files we create ourselves
+		switch config.VerboseLevel {
+		case 0, 1:
+			// Don't print anything we're 'quiet' / this is not an error
+		case 2:
+			displayFileMessageNoDigest(path, "skipping integrity sidecar file")
+		}
+		return nil
+	}
+
+	if fileType := integ_specialFileType(fileinfo); fileType != "" {
+		// Don't try to read pipes, sockets or devices, reading them could block forever or never end
+		switch config.VerboseLevel {
+		case 0, 1:
+			// Don't print anything we're 'quiet' / this is not an error
+		case 2:
+			displayFileMessageNoDigest(path, "skipping "+fileType)
+		}
+		return nil
+	}
+
 	if !fileinfo.IsDir() {
+		// A file which can't be opened is skipped like an unreadable directory, so it's counted in the summary
+		// Recorded symlinks are handled without opening what they point to
+		if fileinfo.Mode()&os.ModeSymlink == 0 {
+			f, err := os.Open(path)
+			if err != nil {
+				return integ_walkError(path, fileinfo, err)
+			}
+			f.Close()
+		}
+
 		var currentFile integrity_fileCard
 		currentFile.FileInfo = &fileinfo
 		currentFile.fullpath = path
@@ -446,9 +602,36 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 		// Generate the display path here as most options will need it
 		var fileDisplayPath string = integ_generatefileDisplayPath(&currentFile)
 
+		// Work out which digests apply to this file
+		var digestNames []string = config.digestNames
+		if config.Option_AutoDigest {
+			if digestNames, err = integ_autoDigestNames(&currentFile); err != nil {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Always output errors even if we're 'quiet'
+					displayFileErrorMessageNoDigest(fileDisplayPath, "FAILED")
+				case 2:
+					displayFileErrorMessageNoDigest(fileDisplayPath, fmt.Sprintf("FAILED : Error detecting file type : %s", err.Error()))
+				}
+				return nil
+			}
+			config.log("debug", "auto digestNames: '%s'\n", digestNames)
+		}
+
+		// Hard links share their checksums, so only the first path seen for each file is hashed
+		if key, multiLinked := integ_inodeKey(fileinfo); multiLinked && (config.Action == "add" || config.Action == "check") {
+			if first, seen := config.hardlinks[key]; seen {
+				integ_reportHardlink(fileDisplayPath, first)
+				return nil
+			}
+			defer func() {
+				config.hardlinks[key] = hardlinkRecord{path: fileDisplayPath, failed: currentFile.failed}
+			}()
+		}
+
 		switch config.Action {
 		case "list":
-			for _, digestName := range config.digestNames {
+			for _, digestName := range digestNames {
 				config.DigestName = digestName
 				config.xattribute_fullname = config.xattribute_prefix + config.DigestName
 				config.log("debug", "list: '%s'\n", config.xattribute_fullname)
@@ -459,7 +642,7 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 			}
 
 		case "delete":
-			for _, digestName := range config.digestNames {
+			for _, digestName := range digestNames {
 				config.DigestName = digestName
 				config.xattribute_fullname = config.xattribute_prefix + config.DigestName
 				config.log("debug", "delete: '%s'\n", config.xattribute_fullname)
@@ -495,7 +678,7 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 			}
 
 		case "add":
-			for _, digestName := range config.digestNames {
+			for _, digestName := range digestNames {
 				config.DigestName = digestName
 				config.xattribute_fullname = config.xattribute_prefix + config.DigestName
 				config.log("debug", "add: '%s'\n", config.xattribute_fullname)
@@ -503,6 +686,7 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 					var haveDigestStored bool
 					haveDigestStored, err = integ_testChecksumStored(&currentFile)
 					if err != nil {
+						currentFile.failed = true
 						switch config.VerboseLevel {
 						case 0, 1:
 							// Always output errors even if we're 'quiet'
@@ -526,6 +710,7 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 
 				// If we've reached here we must want to add the checksum
 				if err = integ_addChecksum(&currentFile); err != nil {
+					currentFile.failed = true
 					switch config.VerboseLevel {
 					case 0, 1:
 						// Always output errors even if we're 'quiet'
@@ -546,12 +731,13 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 			}
 
 		case "check":
-			for _, digestName := range config.digestNames {
+			for _, digestName := range digestNames {
 				config.DigestName = digestName
 				config.xattribute_fullname = config.xattribute_prefix + config.DigestName
 				config.log("debug", "check: '%s'\n", config.xattribute_fullname)
 				var haveDigestStored bool
 				if haveDigestStored, err = integ_testChecksumStored(&currentFile); err != nil {
+					currentFile.failed = true
 					switch config.VerboseLevel {
 					case 0, 1:
 						// Always output errors even if we're 'quiet'
@@ -563,6 +749,7 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 				} else {
 					if haveDigestStored {
 						if err = integ_checkChecksum(&currentFile); err != nil {
+							currentFile.failed = true
 							switch config.VerboseLevel {
 							case 0, 1:
 								// Always output errors even if we're 'quiet'
@@ -581,6 +768,31 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 							}
 						}
 					} else {
+						if config.Option_ValidateFormat {
+							formatName, err := integ_validateFormat(currentFile.fullpath)
+							if err != errFormatNotSupported {
+								if err != nil {
+									currentFile.failed = true
+									switch config.VerboseLevel {
+									case 0, 1:
+										// Always output errors even if we're 'quiet'
+										displayFileErrorMessage(fileDisplayPath, "no checksum : FORMAT FAILED")
+									case 2:
+										displayFileErrorMessage(fileDisplayPath, fmt.Sprintf("no checksum : FORMAT FAILED : %s : %s", formatName, err.Error()))
+									}
+								} else {
+									switch config.VerboseLevel {
+									case 0:
+										// Don't print anything we're 'quiet'
+									case 1:
+										displayFileMessage(fileDisplayPath, "no checksum : FORMAT PASSED")
+									case 2:
+										displayFileMessage(fileDisplayPath, fmt.Sprintf("no checksum : FORMAT PASSED : %s", formatName))
+									}
+								}
+								return nil
+							}
+						}
 						switch config.VerboseLevel {
 						case 0:
 							// Musing: is it an 'error' if we don't have a checksum?
@@ -631,6 +843,124 @@ func handle_path(path string, fileinfo os.FileInfo, err error) error {
 					displayFileMessage(fileDisplayPath, "RENAMED : Renamed any old integrity attributes")
 				}
 			}
+		case "add-parity":
+			if !config.Option_Force {
+				if _, err = os.Stat(paritySidecarPath(currentFile.fullpath)); err == nil {
+					switch config.VerboseLevel {
+					case 0:
+						// Don't print anything we're 'quiet'
+					case 1:
+						displayFileMessageNoDigest(fileDisplayPath, "parity skipped")
+					case 2:
+						displayFileMessageNoDigest(fileDisplayPath, "parity skipped : We already have parity data stored")
+					}
+					return nil
+				}
+			}
+			header, err := integ_addParity(&currentFile)
+			if err != nil {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Always output errors even if we're 'quiet'
+					displayFileErrorMessageNoDigest(fileDisplayPath, "parity FAILED")
+				case 2:
+					displayFileErrorMessageNoDigest(fileDisplayPath, fmt.Sprintf("parity FAILED : Error adding parity : %s", err.Error()))
+				}
+			} else {
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1:
+					displayFileMessageNoDigest(fileDisplayPath, "parity added")
+				case 2:
+					displayFileMessageNoDigest(fileDisplayPath, fmt.Sprintf("parity added : %d stripes of %d data + %d parity blocks of %d bytes", header.stripeCount(), header.DataShards, header.ParityShards, header.BlockSize))
+				}
+			}
+
+		case "repair":
+			// Only repair files which fail their stored checksums, so out of date parity data can't undo a later change
+			storedDigests, passedDigests := 0, 0
+			for _, digestName := range digestNames {
+				config.DigestName = digestName
+				config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+				if haveDigestStored, err := integ_testChecksumStored(&currentFile); err != nil || !haveDigestStored {
+					continue
+				}
+				storedDigests++
+				if err = integ_checkChecksum(&currentFile); err == nil {
+					passedDigests++
+				}
+			}
+			if storedDigests > 0 && passedDigests == storedDigests {
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1:
+					displayFileMessageNoDigest(fileDisplayPath, "no damage found")
+				case 2:
+					displayFileMessageNoDigest(fileDisplayPath, "no damage found : stored checksums PASSED")
+				}
+				return nil
+			}
+
+			repair, err := integ_repairFile(&currentFile)
+			if err == errParityNotFound {
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1:
+					displayFileMessageNoDigest(fileDisplayPath, "no parity data")
+				case 2:
+					displayFileMessageNoDigest(fileDisplayPath, "no parity data, skipped")
+				}
+				return nil
+			} else if err == nil && repair == nil {
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1, 2:
+					displayFileMessageNoDigest(fileDisplayPath, "no damage found")
+				}
+				return nil
+			}
+			// Only write the repaired blocks back once the repaired copy matches the stored checksums
+			var checksums map[string]string
+			if err == nil {
+				checksums, err = integ_replaceRepaired(&currentFile, repair, digestNames)
+			}
+			if err != nil {
+				currentFile.failed = true
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Always output errors even if we're 'quiet'
+					displayFileErrorMessageNoDigest(fileDisplayPath, "REPAIR FAILED")
+				case 2:
+					displayFileErrorMessageNoDigest(fileDisplayPath, fmt.Sprintf("REPAIR FAILED : %s", err.Error()))
+				}
+				return nil
+			}
+			switch config.VerboseLevel {
+			case 0:
+				// Don't print anything we're 'quiet'
+			case 1, 2:
+				displayFileMessageNoDigest(fileDisplayPath, fmt.Sprintf("REPAIRED : %d blocks", len(repair.blocks)))
+			}
+			for _, digestName := range digestNames {
+				checksum, checked := checksums[digestName]
+				if !checked {
+					continue
+				}
+				config.DigestName = digestName
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1:
+					displayFileMessage(fileDisplayPath, "PASSED")
+				case 2:
+					displayFileMessage(fileDisplayPath, fmt.Sprintf("%s : PASSED", checksum))
+				}
+			}
+
 		default:
 			config.log("error", "Error : Unknown action \"%s\"\n", config.Action)
 			config.returnCode = 9 // Unknown action
@@ -3,6 +3,7 @@ package integrity_test
 import (
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 
 	"github.com/greycubesgav/integrity/pkg/integrity"
@@ -36,7 +37,57 @@ func TestIntegrity(t *testing.T) {
 			if err != nil {
 				return err
 			}
+			err = exec.Command("cp", "-rf", "testdata/data/archive.zip", env.WorkDir).Run()
+			if err != nil {
+				return err
+			}
+			err = exec.Command("cp", "-rf", "testdata/data/sample.flac", env.WorkDir).Run()
+			if err != nil {
+				return err
+			}
 			return nil
 		},
 	})
 }
+
+// Test the oshash of non-file sources against the same corpus as integrity_oshash.txtar
+func TestOshashFromReaderAt(t *testing.T) {
+	pattern := strings.Repeat("integrity\n", 30000)
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, ""},
+		{1, "0000000000000001"},
+		{7, "6969726765746e70"},
+		{65536, "c690a8a6a0aad40c"},
+		{131071, "b83dae4eaaba248e"},
+		{131072, "b39d49ad4bb141fa"},
+		{131073, "b698a948aa52369f"},
+		{250007, "bdab9ca7a3a901c7"},
+	}
+	for _, test := range tests {
+		got, err := integrity.OshashFromReaderAt(strings.NewReader(pattern[:test.size]), test.size)
+		if err != nil {
+			t.Errorf("OshashFromReaderAt(%d) error: %s", test.size, err)
+		} else if got != test.want {
+			t.Errorf("OshashFromReaderAt(%d) = %s, want %s", test.size, got, test.want)
+		}
+	}
+
+	// Data whose hash can be worked out by hand, a 64k head of 0x01 bytes and a 64k tail of 0x02 bytes
+	// sum to 8192 * 0x0303030303030303, plus the size
+	headTail := strings.Repeat("\x01", 65536) + strings.Repeat("\x00", 168928) + strings.Repeat("\x02", 65536)
+	if got, err := integrity.OshashFromReaderAt(strings.NewReader(headTail), int64(len(headTail))); err != nil || got != "606060606064f3e0" {
+		t.Errorf("OshashFromReaderAt(head and tail) = %s, %v, want 606060606064f3e0", got, err)
+	}
+	zeros := strings.Repeat("\x00", 200000)
+	if got, err := integrity.OshashFromReaderAt(strings.NewReader(zeros), int64(len(zeros))); err != nil || got != "0000000000030d40" {
+		t.Errorf("OshashFromReaderAt(zeros) = %s, %v, want 0000000000030d40", got, err)
+	}
+
+	// Asking for more data than the source holds is an error, not a short read
+	if _, err := integrity.OshashFromReaderAt(strings.NewReader(pattern[:1000]), 2000); err == nil {
+		t.Errorf("OshashFromReaderAt with short source returned no error")
+	}
+}
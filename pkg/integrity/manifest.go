@@ -0,0 +1,219 @@
+package integrity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version of the manifest file format written by writeManifest
+const manifestVersion = 1
+
+// manifestEntry records a single file within a manifest
+type manifestEntry struct {
+	Path     string `json:"path"` // relative to the manifest root, using '/' separators
+	Size     int64  `json:"size"`
+	ModTime  string `json:"mtime,omitempty"` // RFC 3339 modification time, missing from older snapshots
+	Checksum string `json:"checksum"`
+}
+
+// manifest records every file below a root directory along with its size and checksum
+type manifest struct {
+	Version int             `json:"version"`
+	Root    string          `json:"root"`
+	Digest  string          `json:"digest"`
+	Created string          `json:"created"`
+	Files   []manifestEntry `json:"files"`
+}
+
+// manifestChange pairs up an entry from an old manifest with the matching entry in a new one
+type manifestChange struct {
+	old manifestEntry
+	new manifestEntry
+}
+
+// manifestDiff holds the differences between two manifests
+type manifestDiff struct {
+	unchanged []manifestEntry
+	changed   []manifestChange
+	renamed   []manifestChange
+	missing   []manifestEntry
+	added     []manifestEntry
+}
+
+// integ_getOrGenerateChecksum returns the checksum stored for the current digest
+// falling back to calculating it, without storing it, when none is stored or --rehash is given
+func integ_getOrGenerateChecksum(currentFile *integrity_fileCard) (string, error) {
+	if config.Option_Rehash {
+		err := integ_generateChecksum(currentFile)
+		return currentFile.checksum, err
+	}
+	haveDigestStored, err := integ_testChecksumStored(currentFile)
+	if err != nil {
+		return "", err
+	}
+	if haveDigestStored {
+		err = integ_getChecksum(currentFile)
+	} else {
+		err = integ_generateChecksum(currentFile)
+	}
+	return currentFile.checksum, err
+}
+
+// integ_scanManifest builds a manifest of every regular file below root for the current digest
+// Files which can't be read are reported and left out of the manifest, as is the file at excludePath
+//
+// Stored checksums are trusted unless --rehash is given, so a file edited without updating its checksum
+// would go unnoticed. When a previous manifest is given, files whose size or modification time differ from
+// their entry in it are always rehashed.
+func integ_scanManifest(root string, excludePath string, previous *manifest) (*manifest, error) {
+	m := &manifest{Version: manifestVersion, Root: root, Digest: config.DigestName, Created: time.Now().UTC().Format(time.RFC3339)}
+	excludeAbs, _ := filepath.Abs(excludePath)
+	previousByPath := make(map[string]manifestEntry)
+	if previous != nil {
+		for _, entry := range previous.Files {
+			previousByPath[entry.Path] = entry
+		}
+	}
+	err := integ_walk(root, func(path string, fileinfo os.FileInfo, err error) error {
+		if err != nil {
+			return integ_walkError(path, fileinfo, err)
+		}
+		if !fileinfo.Mode().IsRegular() || integ_isSidecar(fileinfo.Name()) {
+			return nil
+		}
+		if pathAbs, _ := filepath.Abs(path); excludePath != "" && pathAbs == excludeAbs {
+			return nil
+		}
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entry := manifestEntry{Path: filepath.ToSlash(relativePath), Size: fileinfo.Size(), ModTime: fileinfo.ModTime().UTC().Format(time.RFC3339Nano)}
+
+		var currentFile integrity_fileCard
+		currentFile.FileInfo = &fileinfo
+		currentFile.fullpath = path
+		var checksum string
+		if previousEntry, exists := previousByPath[entry.Path]; exists && (previousEntry.Size != entry.Size || (previousEntry.ModTime != "" && previousEntry.ModTime != entry.ModTime)) {
+			// The file has been modified, its stored checksum may not have been updated
+			config.log("debug", "integ_scanManifest %s modified, rehashing\n", path)
+			err = integ_generateChecksum(&currentFile)
+			checksum = currentFile.checksum
+		} else {
+			checksum, err = integ_getOrGenerateChecksum(&currentFile)
+		}
+		if err != nil {
+			displayFileErrorMessage(path, fmt.Sprintf("skipped : %s", err.Error()))
+			return nil
+		}
+		entry.Checksum = checksum
+		m.Files = append(m.Files, entry)
+		return nil
+	})
+	return m, err
+}
+
+// readManifest reads a manifest file written by writeManifest
+func readManifest(manifestPath string) (*manifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s : %w", manifestPath, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d in %s", m.Version, manifestPath)
+	}
+	return m, nil
+}
+
+// writeManifest writes a manifest as JSON
+func writeManifest(manifestPath string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, append(data, '\n'), 0644)
+}
+
+// diffManifests compares two manifests by relative path, then pairs up any files missing from
+// the new manifest with new files of the same size and checksum to find renamed files
+func diffManifests(oldManifest *manifest, newManifest *manifest) *manifestDiff {
+	diff := &manifestDiff{}
+	newByPath := make(map[string]manifestEntry)
+	for _, entry := range newManifest.Files {
+		newByPath[entry.Path] = entry
+	}
+	oldPaths := make(map[string]bool)
+	for _, oldEntry := range oldManifest.Files {
+		oldPaths[oldEntry.Path] = true
+		if newEntry, exists := newByPath[oldEntry.Path]; exists {
+			if oldEntry.Size == newEntry.Size && oldEntry.Checksum == newEntry.Checksum {
+				diff.unchanged = append(diff.unchanged, newEntry)
+			} else {
+				diff.changed = append(diff.changed, manifestChange{old: oldEntry, new: newEntry})
+			}
+		} else {
+			diff.missing = append(diff.missing, oldEntry)
+		}
+	}
+
+	// Index the new files by size and checksum so missing files can be matched to them
+	addedByContent := make(map[string][]manifestEntry)
+	for _, newEntry := range newManifest.Files {
+		if !oldPaths[newEntry.Path] {
+			key := fmt.Sprintf("%d:%s", newEntry.Size, newEntry.Checksum)
+			addedByContent[key] = append(addedByContent[key], newEntry)
+		}
+	}
+	sortManifestEntries(diff.missing)
+	var stillMissing []manifestEntry
+	for _, oldEntry := range diff.missing {
+		key := fmt.Sprintf("%d:%s", oldEntry.Size, oldEntry.Checksum)
+		if candidates := addedByContent[key]; len(candidates) > 0 {
+			sortManifestEntries(candidates)
+			diff.renamed = append(diff.renamed, manifestChange{old: oldEntry, new: candidates[0]})
+			addedByContent[key] = candidates[1:]
+		} else {
+			stillMissing = append(stillMissing, oldEntry)
+		}
+	}
+	diff.missing = stillMissing
+	for _, candidates := range addedByContent {
+		diff.added = append(diff.added, candidates...)
+	}
+
+	sortManifestEntries(diff.unchanged)
+	sortManifestEntries(diff.added)
+	sort.Slice(diff.changed, func(i, j int) bool { return diff.changed[i].new.Path < diff.changed[j].new.Path })
+	sort.Slice(diff.renamed, func(i, j int) bool { return diff.renamed[i].new.Path < diff.renamed[j].new.Path })
+	return diff
+}
+
+func sortManifestEntries(entries []manifestEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+}
+
+// manifestDisplayPath returns the path to show for a manifest entry
+func manifestDisplayPath(root string, entry manifestEntry) string {
+	return filepath.Join(root, filepath.FromSlash(entry.Path))
+}
+
+// summary returns a one line count of each type of difference
+func (diff *manifestDiff) summary() string {
+	counts := []string{
+		fmt.Sprintf("%d unchanged", len(diff.unchanged)),
+		fmt.Sprintf("%d changed", len(diff.changed)),
+		fmt.Sprintf("%d renamed", len(diff.renamed)),
+		fmt.Sprintf("%d missing", len(diff.missing)),
+		fmt.Sprintf("%d new", len(diff.added)),
+	}
+	return strings.Join(counts, ", ")
+}
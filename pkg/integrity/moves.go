@@ -0,0 +1,116 @@
+package integrity
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// integ_loadMovesSide returns the manifest for one side of a moves comparison
+// either read from a snapshot file or, for a directory, left nil to be scanned once the digest is known
+func integ_loadMovesSide(sidePath string) (*manifest, error) {
+	fileinfo, err := os.Stat(sidePath)
+	if err != nil {
+		return nil, err
+	}
+	if fileinfo.IsDir() {
+		return nil, nil
+	}
+	return readManifest(sidePath)
+}
+
+// integ_handleMoves compares an old and a new directory, or snapshot, and reports files which have been
+// moved or renamed by matching the size and checksum of files which are only found on one side
+func integ_handleMoves(oldPath string, newPath string) {
+	sidePaths := []string{oldPath, newPath}
+	manifests := make([]*manifest, len(sidePaths))
+
+	// Snapshots fix the digest used, otherwise we use the digest asked for
+	digestName := ""
+	for i, sidePath := range sidePaths {
+		m, err := integ_loadMovesSide(sidePath)
+		if err != nil {
+			displayFileErrorMessageNoDigest(sidePath, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		}
+		if m != nil {
+			if digestName != "" && digestName != m.Digest {
+				config.log("error", "Error : --moves snapshots use different digests '%s' and '%s'\n", digestName, m.Digest)
+				config.returnCode = 18 // Invalid moves options
+				return
+			}
+			digestName = m.Digest
+		}
+		manifests[i] = m
+	}
+	if digestName == "" {
+		if len(config.digestNames) != 1 {
+			config.log("error", "Error : --moves takes a single digest\n")
+			config.returnCode = 18 // Invalid moves options
+			return
+		}
+		digestName = config.digestNames[0]
+	}
+	if err := integ_useDigest(digestName); err != nil {
+		config.log("error", "Error : %s\n", err.Error())
+		config.returnCode = 5 // Unknown digest
+		return
+	}
+	// Directories compared with a snapshot rehash files modified since it was saved
+	snapshots := []*manifest{manifests[0], manifests[1]}
+	for i, sidePath := range sidePaths {
+		if manifests[i] != nil {
+			continue
+		}
+		var err error
+		if manifests[i], err = integ_scanManifest(sidePath, "", snapshots[1-i]); err != nil {
+			displayFileErrorMessageNoDigest(sidePath, fmt.Sprintf("ERROR : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		}
+	}
+
+	diff := diffManifests(manifests[0], manifests[1])
+	moved, renamed := 0, 0
+	for _, change := range diff.renamed {
+		// Files staying in the same directory have been renamed, otherwise they have moved
+		message := fmt.Sprintf("moved from %s", change.old.Path)
+		if path.Dir(change.old.Path) == path.Dir(change.new.Path) {
+			message = fmt.Sprintf("renamed from %s", change.old.Path)
+			renamed++
+		} else {
+			moved++
+		}
+		switch config.VerboseLevel {
+		case 0:
+			// Don't print anything we're 'quiet'
+		case 1:
+			displayFileMessage(change.new.Path, message)
+		case 2:
+			displayFileMessage(change.new.Path, fmt.Sprintf("%s : %s", message, change.new.Checksum))
+		}
+	}
+	if config.VerboseLevel == 2 {
+		for _, change := range diff.changed {
+			displayFileMessage(change.new.Path, "changed")
+		}
+		for _, entry := range diff.missing {
+			displayFileMessage(entry.Path, "removed")
+		}
+		for _, entry := range diff.added {
+			displayFileMessage(entry.Path, "added")
+		}
+	}
+	switch config.VerboseLevel {
+	case 0:
+		// Don't print anything we're 'quiet'
+	case 1, 2:
+		displayFileMessage(newPath, fmt.Sprintf("%d moved, %d renamed, %d changed, %d removed, %d added, %d unchanged",
+			moved, renamed, len(diff.changed), len(diff.missing), len(diff.added), len(diff.unchanged)))
+	}
+	// Files removed or added without a match on the other side are differences, like a failed snapshot check
+	if len(diff.missing)+len(diff.added) > 0 {
+		config.returnCode = 29 // Differences found
+	}
+}
@@ -1,18 +1,16 @@
 package integrity
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 )
 
-// oshashFromFilePath calculates the hash using the same algorithm that
-// OpenSubtitles.org uses.
-// https://trac.opensubtitles.org/projects/opensubtitles/wiki/HashSourceCodes
-//
-// Calculation is as follows:
-// size + 64 bit checksum of the first and last 64k bytes of the file.
+// Size of the head and tail chunks read by the oshash algorithm
+const oshashChunkSize = 64 * 1024
+
+// oshashFromFilePath calculates the oshash of a file, see OshashFromReaderAt
 func oshashFromFilePath(filePath string) (string, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -30,59 +28,69 @@ func oshashFromFilePath(filePath string) (string, error) {
 		return "", err
 	}
 
-	fileSize := int64(fi.Size())
+	return OshashFromReaderAt(f, fi.Size())
+}
 
-	if fileSize == 0 {
+// OshashFromReaderAt calculates the hash of size bytes of data read from r using
+// the same algorithm that OpenSubtitles.org uses.
+// https://trac.opensubtitles.org/projects/opensubtitles/wiki/HashSourceCodes
+//
+// Calculation is as follows:
+// size + 64 bit little endian checksum of the first and last 64k bytes of the data.
+//
+// The reference implementations only define the hash for data of at least 128k.
+// For smaller data the head and tail chunks are each the first and last 64k (or
+// the whole data if shorter), so they overlap, and any bytes past the last whole
+// 64 bit word of the joined chunks are ignored. This is the behaviour integrity
+// has always had, so oshash values already stored on small files still verify.
+// Empty data has no hash and returns an empty string, as integrity always has.
+func OshashFromReaderAt(r io.ReaderAt, size int64) (string, error) {
+	if size < 0 {
+		return "", fmt.Errorf("oshash: invalid size %d", size)
+	}
+	if size == 0 {
 		return "", nil
 	}
 
-	const chunkSize = 64 * 1024
-	fileChunkSize := int64(chunkSize)
-	if fileSize < fileChunkSize {
-		fileChunkSize = fileSize
+	chunkSize := int64(oshashChunkSize)
+	if size < chunkSize {
+		chunkSize = size
 	}
 
-	head := make([]byte, fileChunkSize)
-	tail := make([]byte, fileChunkSize)
-
-	// read the head of the file into the start of the buffer
-	_, err = f.Read(head)
-	if err != nil {
+	// read the head and tail of the data into one buffer
+	buf := make([]byte, 2*chunkSize)
+	if err := oshashReadFullAt(r, buf[:chunkSize], 0); err != nil {
 		return "", err
 	}
-
-	// seek to the end of the file - the chunk size
-	_, err = f.Seek(-fileChunkSize, 2)
-	if err != nil {
+	if err := oshashReadFullAt(r, buf[chunkSize:], size-chunkSize); err != nil {
 		return "", err
 	}
 
-	// read the tail of the file
-	_, err = f.Read(tail)
-	if err != nil {
-		return "", err
-	}
+	// sum the chunks and add the size
+	sum := oshashSumChunk(buf) + uint64(size)
 
-	// put the head and tail together
-	buf := append(head, tail...)
+	// output as hex
+	return fmt.Sprintf("%016x", sum), nil
+}
 
-	// convert bytes into uint64
-	ints := make([]uint64, len(buf)/8)
-	reader := bytes.NewReader(buf)
-	err = binary.Read(reader, binary.LittleEndian, &ints)
-	if err != nil {
-		return "", err
+// oshashReadFullAt fills buf from r at the given offset, failing on any short read
+func oshashReadFullAt(r io.ReaderAt, buf []byte, offset int64) error {
+	n, err := r.ReadAt(buf, offset)
+	if n == len(buf) {
+		// ReadAt may return io.EOF along with a full buffer when reading up to the end of the data
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
+	return err
+}
 
-	// sum the integers
+// oshashSumChunk sums the buffer as 64 bit little endian integers, ignoring any trailing partial word
+func oshashSumChunk(buf []byte) uint64 {
 	var sum uint64
-	for _, v := range ints {
-		sum += v
+	for i := 0; i+8 <= len(buf); i += 8 {
+		sum += binary.LittleEndian.Uint64(buf[i : i+8])
 	}
-
-	// add the filesize
-	sum += uint64(fileSize)
-
-	// output as hex
-	return fmt.Sprintf("%016x", sum), nil
+	return sum
 }
@@ -0,0 +1,463 @@
+package integrity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/pkg/xattr"
+)
+
+// Suffix of the hidden sidecar file holding a file's parity data
+const paritySidecarSuffix = ".integrity-parity"
+
+// Magic bytes at the start of every parity sidecar file
+const parityMagic = "INTPAR01"
+
+// Maximum number of data blocks in each Reed-Solomon stripe, each stripe is held in memory while
+// encoding or repairing so this with the block size sets the memory used
+const parityDataShards = 20
+
+// Smallest block size used for parity data, small files use smaller blocks than --block-size
+// so a damaged block doesn't require a whole file's worth of parity to repair
+const parityMinBlockSize = 64
+
+// Number of bytes of each block's sha256 kept to find damaged blocks
+const parityHashSize = 16
+
+// Errors returned by integ_repairFile
+var errParityNotFound = errors.New("no parity data found")
+var errParityUnrepairable = errors.New("too many damaged blocks to repair")
+var errParityNoChecksum = errors.New("no checksum stored to verify the repaired file against")
+var errParityRepairMismatch = errors.New("repaired file doesn't match the stored checksum, the parity data may be out of date")
+
+// parityHeader describes the layout of a parity sidecar file, which is:
+//
+//	header | data block hashes | parity block hashes | sha256 of all previous bytes | parity blocks
+type parityHeader struct {
+	Magic        [8]byte
+	FileSize     uint64
+	BlockSize    uint32
+	DataShards   uint16
+	ParityShards uint16
+}
+
+func (h *parityHeader) blockCount() int64 {
+	return (int64(h.FileSize) + int64(h.BlockSize) - 1) / int64(h.BlockSize)
+}
+
+func (h *parityHeader) stripeCount() int64 {
+	return (h.blockCount() + int64(h.DataShards) - 1) / int64(h.DataShards)
+}
+
+// paritySidecarPath returns the path of the parity sidecar file for a file
+func paritySidecarPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+paritySidecarSuffix)
+}
+
+// parityBlockHash returns the truncated sha256 used to identify damaged blocks
+func parityBlockHash(block []byte) []byte {
+	blockHash := sha256.Sum256(block)
+	return blockHash[:parityHashSize]
+}
+
+// parityReadStripe reads the data blocks of a stripe from the file, padding any short block with zeros
+func parityReadStripe(f io.ReaderAt, header *parityHeader, stripe int64, shards [][]byte) error {
+	for i := 0; i < int(header.DataShards); i++ {
+		clear(shards[i])
+		offset := (stripe*int64(header.DataShards) + int64(i)) * int64(header.BlockSize)
+		if offset >= int64(header.FileSize) {
+			continue
+		}
+		length := min(int64(header.BlockSize), int64(header.FileSize)-offset)
+		if _, err := f.ReadAt(shards[i][:length], offset); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// integ_addParity writes a sidecar file of Reed-Solomon parity data for a file
+// Returns the header describing the parity data written
+func integ_addParity(currentFile *integrity_fileCard) (*parityHeader, error) {
+	f, err := os.Open(currentFile.fullpath)
+	if err != nil {
+		return nil, err
+	}
+	// Add a function to defer to ensure any issue closing the file is reported
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing file: %s\n", err)
+		}
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fileSize := fi.Size()
+	if fileSize == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	// Small files are split into smaller blocks, rounded to a multiple of 64 bytes
+	blockSize := min(config.blockSize, (fileSize+parityDataShards-1)/parityDataShards)
+	blockSize = max(parityMinBlockSize, (blockSize+63)/64*64)
+	if blockSize > int64(^uint32(0)) {
+		return nil, fmt.Errorf("block size %d too large for parity data", blockSize)
+	}
+	header := &parityHeader{FileSize: uint64(fileSize), BlockSize: uint32(blockSize)}
+	copy(header.Magic[:], parityMagic)
+	header.DataShards = uint16(min(int64(parityDataShards), header.blockCount()))
+	header.ParityShards = uint16(max(1, (int(header.DataShards)*config.parityRedundancy+99)/100))
+
+	encoder, err := reedsolomon.New(int(header.DataShards), int(header.ParityShards))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parity blocks are written to a temporary file while the hashes are collected for the header
+	sidecarPath := paritySidecarPath(currentFile.fullpath)
+	parityData, err := os.CreateTemp(filepath.Dir(sidecarPath), filepath.Base(sidecarPath)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(parityData.Name())
+	defer parityData.Close()
+	parityWriter := bufio.NewWriter(parityData)
+
+	var dataHashes, parityHashes bytes.Buffer
+	shards := make([][]byte, header.DataShards+header.ParityShards)
+	for i := range shards {
+		shards[i] = make([]byte, header.BlockSize)
+	}
+	for stripe := int64(0); stripe < header.stripeCount(); stripe++ {
+		if err = parityReadStripe(f, header, stripe, shards); err != nil {
+			return nil, err
+		}
+		if err = encoder.Encode(shards); err != nil {
+			return nil, err
+		}
+		for i, shard := range shards {
+			if i < int(header.DataShards) {
+				dataHashes.Write(parityBlockHash(shard))
+			} else {
+				parityHashes.Write(parityBlockHash(shard))
+				if _, err = parityWriter.Write(shard); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err = parityWriter.Flush(); err != nil {
+		return nil, err
+	}
+	// Only keep the hashes of blocks which hold file data, the rest of the last stripe is padding
+	dataHashes.Truncate(int(header.blockCount()) * parityHashSize)
+
+	// Write the sidecar file, header and hashes followed by the parity blocks
+	sidecar, err := os.CreateTemp(filepath.Dir(sidecarPath), filepath.Base(sidecarPath)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(sidecar.Name())
+	defer sidecar.Close()
+	headerHash := sha256.New()
+	sidecarWriter := bufio.NewWriter(io.MultiWriter(sidecar, headerHash))
+	if err = binary.Write(sidecarWriter, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	sidecarWriter.Write(dataHashes.Bytes())
+	sidecarWriter.Write(parityHashes.Bytes())
+	if err = sidecarWriter.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err = sidecar.Write(headerHash.Sum(nil)); err != nil {
+		return nil, err
+	}
+	if _, err = parityData.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(sidecar, parityData); err != nil {
+		return nil, err
+	}
+	if err = sidecar.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(sidecar.Name(), sidecarPath); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// parityRepair is a copy of a file with its damaged blocks rebuilt, and where those blocks are
+type parityRepair struct {
+	path   string
+	blocks []parityBlock
+}
+
+// parityBlock is the position of a rebuilt block within a file
+type parityBlock struct {
+	offset int64
+	length int64
+}
+
+// integ_repairFile uses a file's parity sidecar to find and rebuild any damaged blocks
+// The file itself is left untouched, the rebuilt blocks are written to a copy of the file next to it
+// Returns the repaired copy, or nil if no blocks were damaged
+func integ_repairFile(currentFile *integrity_fileCard) (repair *parityRepair, err error) {
+	sidecar, err := os.Open(paritySidecarPath(currentFile.fullpath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errParityNotFound
+		}
+		return nil, err
+	}
+	defer sidecar.Close()
+
+	// Read and verify the header and block hashes
+	sidecarReader := bufio.NewReader(sidecar)
+	headerHash := sha256.New()
+	hashedReader := io.TeeReader(sidecarReader, headerHash)
+	header := &parityHeader{}
+	if err = binary.Read(hashedReader, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("parity data corrupt : %w", err)
+	}
+	if string(header.Magic[:]) != parityMagic || header.BlockSize == 0 || header.DataShards == 0 || header.ParityShards == 0 {
+		return nil, errors.New("parity data corrupt : invalid header")
+	}
+	dataHashes := make([]byte, header.blockCount()*parityHashSize)
+	parityHashes := make([]byte, header.stripeCount()*int64(header.ParityShards)*parityHashSize)
+	if _, err = io.ReadFull(hashedReader, dataHashes); err != nil {
+		return nil, fmt.Errorf("parity data corrupt : %w", err)
+	}
+	if _, err = io.ReadFull(hashedReader, parityHashes); err != nil {
+		return nil, fmt.Errorf("parity data corrupt : %w", err)
+	}
+	storedHeaderHash := make([]byte, sha256.Size)
+	if _, err = io.ReadFull(sidecarReader, storedHeaderHash); err != nil {
+		return nil, fmt.Errorf("parity data corrupt : %w", err)
+	}
+	if !bytes.Equal(storedHeaderHash, headerHash.Sum(nil)) {
+		return nil, errors.New("parity data corrupt : header checksum mismatch")
+	}
+	parityOffset := int64(binary.Size(header)) + int64(len(dataHashes)) + int64(len(parityHashes)) + sha256.Size
+
+	f, err := os.Open(currentFile.fullpath)
+	if err != nil {
+		return nil, err
+	}
+	// Add a function to defer to ensure any issue closing the file is reported
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing file: %s\n", err)
+		}
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() != int64(header.FileSize) {
+		return nil, fmt.Errorf("file size %d differs from the %d bytes protected by the parity data", fi.Size(), header.FileSize)
+	}
+
+	encoder, err := reedsolomon.New(int(header.DataShards), int(header.ParityShards))
+	if err != nil {
+		return nil, err
+	}
+	shards := make([][]byte, header.DataShards+header.ParityShards)
+	for i := range shards {
+		shards[i] = make([]byte, header.BlockSize)
+	}
+	zeroBlockHash := parityBlockHash(make([]byte, header.BlockSize))
+
+	// The repaired copy is only created once a damaged block is found, and removed if it can't be completed
+	var repaired *os.File
+	defer func() {
+		if repaired != nil {
+			repaired.Close()
+			if err != nil {
+				os.Remove(repaired.Name())
+			}
+		}
+	}()
+	for stripe := int64(0); stripe < header.stripeCount(); stripe++ {
+		if err = parityReadStripe(f, header, stripe, shards); err != nil {
+			return nil, err
+		}
+		// Find the damaged data blocks in this stripe
+		var damaged []int
+		for i := 0; i < int(header.DataShards); i++ {
+			block := stripe*int64(header.DataShards) + int64(i)
+			expectedHash := zeroBlockHash
+			if block < header.blockCount() {
+				expectedHash = dataHashes[block*parityHashSize : (block+1)*parityHashSize]
+			}
+			if !bytes.Equal(parityBlockHash(shards[i]), expectedHash) {
+				damaged = append(damaged, i)
+			}
+		}
+		if len(damaged) == 0 {
+			continue
+		}
+		if len(damaged) > int(header.ParityShards) {
+			return nil, errParityUnrepairable
+		}
+
+		// Load the parity blocks, dropping any which are themselves damaged
+		stripeParityOffset := parityOffset + stripe*int64(header.ParityShards)*int64(header.BlockSize)
+		for i := 0; i < int(header.ParityShards); i++ {
+			shard := shards[int(header.DataShards)+i]
+			if _, err = sidecar.ReadAt(shard, stripeParityOffset+int64(i)*int64(header.BlockSize)); err != nil && err != io.EOF {
+				return nil, err
+			}
+			hashOffset := (stripe*int64(header.ParityShards) + int64(i)) * parityHashSize
+			if !bytes.Equal(parityBlockHash(shard), parityHashes[hashOffset:hashOffset+parityHashSize]) {
+				shards[int(header.DataShards)+i] = nil
+			}
+		}
+		for _, i := range damaged {
+			shards[i] = nil
+		}
+		if err = encoder.ReconstructData(shards); err != nil {
+			if errors.Is(err, reedsolomon.ErrTooFewShards) {
+				return nil, errParityUnrepairable
+			}
+			return nil, err
+		}
+
+		// Write the rebuilt blocks to the copy of the file
+		if repaired == nil {
+			if repaired, err = parityCreateRepairCopy(f, fi); err != nil {
+				return nil, err
+			}
+			repair = &parityRepair{path: repaired.Name()}
+		}
+		for _, i := range damaged {
+			offset := (stripe*int64(header.DataShards) + int64(i)) * int64(header.BlockSize)
+			if offset < int64(header.FileSize) {
+				length := min(int64(header.BlockSize), int64(header.FileSize)-offset)
+				if _, err = repaired.WriteAt(shards[i][:length], offset); err != nil {
+					return nil, err
+				}
+				repair.blocks = append(repair.blocks, parityBlock{offset: offset, length: length})
+			}
+		}
+		// Put back any buffers the reconstruction replaced
+		for i := range shards {
+			if shards[i] == nil || len(shards[i]) != int(header.BlockSize) {
+				shards[i] = make([]byte, header.BlockSize)
+			}
+		}
+	}
+	if repaired == nil {
+		return nil, nil
+	}
+	if err = repaired.Sync(); err != nil {
+		return nil, err
+	}
+	return repair, nil
+}
+
+// parityCreateRepairCopy creates a hidden copy of a file next to it, for damaged blocks to be rebuilt into
+func parityCreateRepairCopy(f *os.File, fi os.FileInfo) (*os.File, error) {
+	repaired, err := os.CreateTemp(filepath.Dir(f.Name()), "."+filepath.Base(f.Name())+".integrity-repair.*")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(repaired, io.NewSectionReader(f, 0, fi.Size()))
+	if err == nil {
+		err = repaired.Chmod(fi.Mode().Perm())
+	}
+	if err != nil {
+		repaired.Close()
+		os.Remove(repaired.Name())
+		return nil, err
+	}
+	return repaired, nil
+}
+
+// integ_replaceRepaired writes a file's rebuilt blocks back into it, once the repaired copy matches every checksum stored for the file
+// Only the rebuilt blocks are written, so the file keeps its inode, links, ownership, attributes and modification time.
+// The repaired copy is always removed, if it doesn't match the file is left as it was.
+// Returns the checksum of the repaired file for each digest checked
+func integ_replaceRepaired(currentFile *integrity_fileCard, repair *parityRepair, digestNames []string) (map[string]string, error) {
+	defer os.Remove(repair.path)
+
+	// Copy the stored checksums so the repaired copy can be checked against them
+	// Blockmap sidecars are referenced by name so the copy in the same directory shares them
+	attributeNames, err := xattr.List(currentFile.fullpath)
+	if err != nil {
+		return nil, err
+	}
+	for _, attributeName := range attributeNames {
+		if !strings.HasPrefix(attributeName, config.xattribute_prefix) {
+			continue
+		}
+		value, err := xattr.Get(currentFile.fullpath, attributeName)
+		if err != nil {
+			return nil, err
+		}
+		if err = xattr.Set(repair.path, attributeName, value); err != nil {
+			return nil, err
+		}
+	}
+
+	var repairedCard integrity_fileCard
+	repairedCard.FileInfo = currentFile.FileInfo
+	repairedCard.fullpath = repair.path
+	checksums := make(map[string]string)
+	for _, digestName := range digestNames {
+		config.DigestName = digestName
+		config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+		if haveDigestStored, err := integ_testChecksumStored(&repairedCard); err != nil {
+			return nil, err
+		} else if !haveDigestStored {
+			continue
+		}
+		if err = integ_checkChecksum(&repairedCard); err != nil {
+			return nil, fmt.Errorf("%w : %s", errParityRepairMismatch, err.Error())
+		}
+		checksums[digestName] = repairedCard.checksum
+	}
+	if len(checksums) == 0 {
+		return nil, errParityNoChecksum
+	}
+	return checksums, parityWriteBlocks(currentFile, repair)
+}
+
+// parityWriteBlocks copies the rebuilt blocks from a repaired copy into the original file
+func parityWriteBlocks(currentFile *integrity_fileCard, repair *parityRepair) error {
+	repaired, err := os.Open(repair.path)
+	if err != nil {
+		return err
+	}
+	defer repaired.Close()
+	f, err := os.OpenFile(currentFile.fullpath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	for _, block := range repair.blocks {
+		if _, err = io.Copy(io.NewOffsetWriter(f, block.offset), io.NewSectionReader(repaired, block.offset, block.length)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// Leave the modification time as it was, the contents are back to what was checksummed
+	return os.Chtimes(currentFile.fullpath, time.Time{}, (*currentFile.FileInfo).ModTime())
+}
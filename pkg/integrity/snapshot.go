@@ -0,0 +1,114 @@
+package integrity
+
+import (
+	"fmt"
+)
+
+// integ_handleSnapshot saves a snapshot of every file below a directory, or compares the
+// directory against a saved snapshot to find files which are missing, new, renamed or changed
+func integ_handleSnapshot(root string) {
+	switch config.Action {
+	case "add":
+		config.DigestName = config.digestNames[0]
+		config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+		m, err := integ_scanManifest(root, config.snapshotPath, nil)
+		if err == nil {
+			err = writeManifest(config.snapshotPath, m)
+		}
+		if err != nil {
+			switch config.VerboseLevel {
+			case 0, 1:
+				// Always output errors even if we're 'quiet'
+				displayFileErrorMessage(root, "snapshot FAILED")
+			case 2:
+				displayFileErrorMessage(root, fmt.Sprintf("snapshot FAILED : Error saving snapshot : %s", err.Error()))
+			}
+			config.returnCode = 13 // Error handling path
+			return
+		}
+		switch config.VerboseLevel {
+		case 0:
+			// Don't print anything we're 'quiet'
+		case 1:
+			displayFileMessage(root, "snapshot saved")
+		case 2:
+			displayFileMessage(root, fmt.Sprintf("snapshot of %d files saved to %s", len(m.Files), config.snapshotPath))
+		}
+
+	case "check":
+		snapshot, err := readManifest(config.snapshotPath)
+		if err != nil {
+			displayFileErrorMessageNoDigest(root, fmt.Sprintf("snapshot FAILED : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		}
+		// Compare using the digest the snapshot was saved with
+		if err = integ_useDigest(snapshot.Digest); err != nil {
+			displayFileErrorMessageNoDigest(root, fmt.Sprintf("snapshot FAILED : %s", err.Error()))
+			config.returnCode = 5 // Unknown digest
+			return
+		}
+		current, err := integ_scanManifest(root, config.snapshotPath, snapshot)
+		if err != nil {
+			displayFileErrorMessage(root, fmt.Sprintf("snapshot FAILED : %s", err.Error()))
+			config.returnCode = 13 // Error handling path
+			return
+		}
+		diff := diffManifests(snapshot, current)
+
+		if config.VerboseLevel == 2 {
+			for _, entry := range diff.unchanged {
+				displayFileMessage(manifestDisplayPath(root, entry), "unchanged")
+			}
+		}
+		// Always output errors even if we're 'quiet'
+		for _, change := range diff.changed {
+			message := "CHANGED"
+			if config.VerboseLevel == 2 && change.old.Size != change.new.Size {
+				message += fmt.Sprintf(" : size changed from %d to %d bytes", change.old.Size, change.new.Size)
+			}
+			displayFileErrorMessage(manifestDisplayPath(root, change.new), message)
+		}
+		for _, entry := range diff.missing {
+			displayFileErrorMessage(manifestDisplayPath(root, entry), "MISSING")
+		}
+		for _, change := range diff.renamed {
+			displayFileErrorMessage(manifestDisplayPath(root, change.new), fmt.Sprintf("RENAMED from %s", manifestDisplayPath(root, change.old)))
+		}
+		for _, entry := range diff.added {
+			displayFileErrorMessage(manifestDisplayPath(root, entry), "NEW")
+		}
+
+		if len(diff.changed)+len(diff.missing)+len(diff.renamed)+len(diff.added) > 0 {
+			displayFileErrorMessage(root, fmt.Sprintf("SNAPSHOT FAILED : %s", diff.summary()))
+			config.returnCode = 29 // Differences found
+		} else {
+			switch config.VerboseLevel {
+			case 0:
+				// Don't print anything we're 'quiet'
+			case 1:
+				displayFileMessage(root, "SNAPSHOT PASSED")
+			case 2:
+				displayFileMessage(root, fmt.Sprintf("SNAPSHOT PASSED : %s", diff.summary()))
+			}
+		}
+
+	default:
+		config.log("error", "Error : action \"%s\" not supported with --snapshot\n", config.Action)
+		config.returnCode = 9 // Unknown action
+	}
+}
+
+// integ_useDigest makes the given digest the current digest, even if it wasn't asked for on the command line
+func integ_useDigest(digestName string) error {
+	if _, isFileDigest := fileDigestTypes[digestName]; !isFileDigest {
+		digest, exists := digestTypes[digestName]
+		if !exists {
+			return fmt.Errorf("unknown digest type '%s'", digestName)
+		}
+		config.digestList[digestName] = digest
+	}
+	config.DigestName = digestName
+	config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+	return nil
+}
@@ -0,0 +1,142 @@
+package integrity
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// integ_hashReader reads all of the data from a reader, writing it on to w if given, and returns the checksum
+// of the data for each crypto.Hash digest. File type digests need the whole file so are left to the caller.
+func integ_hashReader(r io.Reader, w io.Writer, digestNames []string) (map[string]string, error) {
+	hashes := make(map[string]hash.Hash)
+	var writers []io.Writer
+	if w != nil {
+		writers = append(writers, w)
+	}
+	for _, digestName := range digestNames {
+		if _, isFileDigest := fileDigestTypes[digestName]; !isFileDigest {
+			hashes[digestName] = config.digestList[digestName].New()
+			writers = append(writers, hashes[digestName])
+		}
+	}
+	if _, err := io.CopyBuffer(io.MultiWriter(writers...), r, make([]byte, fileBufferSize)); err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string)
+	for digestName, hashFunc := range hashes {
+		checksums[digestName] = hex.EncodeToString(hashFunc.Sum(nil))
+	}
+	return checksums, nil
+}
+
+// integ_handleStdin prints the checksum of the data read from stdin for each digest, in any of the display formats
+func integ_handleStdin() {
+	checksums, err := integ_hashReader(os.Stdin, nil, config.digestNames)
+	if err != nil {
+		displayFileErrorMessageNoDigest("-", fmt.Sprintf("FAILED : Error reading stdin : %s", err.Error()))
+		config.returnCode = 13 // Error handling path
+		return
+	}
+	for _, digestName := range config.digestNames {
+		// The checksum is the result, so is output even if we're 'quiet'
+		config.DigestName = digestName
+		displayFileMessage("-", checksums[digestName])
+	}
+}
+
+// integ_teeTempPath returns the hidden temporary file next to dstPath that the tee subcommand writes to,
+// keeping dstPath's extension so the file type is still recognised
+func integ_teeTempPath(dstPath string) string {
+	return filepath.Join(filepath.Dir(dstPath), fmt.Sprintf(".%s.integrity-tee.%d%s", filepath.Base(dstPath), os.Getpid(), filepath.Ext(dstPath)))
+}
+
+// integ_teeFile writes the data read from a reader to a new file, hashing it as it's written, then adds the
+// checksums to the file once the file has been read back and verified against them
+// The data is written to a temporary file which only replaces any existing file at dstPath once it has been
+// verified. The temporary file is kept if it can't be verified as the data can't be read again.
+func integ_teeFile(r io.Reader, dstPath string) ([]string, map[string]string, error) {
+	if _, err := os.Lstat(dstPath); err == nil && !config.Option_Force {
+		return nil, nil, errCopyDestinationExists
+	}
+	tmpPath := integ_teeTempPath(dstPath)
+	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+	checksums, err := integ_hashReader(r, tmpFile, config.digestNames)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// The data is incomplete, so isn't worth keeping
+		integ_removeCopy(tmpPath)
+		return nil, nil, err
+	}
+
+	tmpInfo, err := os.Stat(tmpPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	digestNames := config.digestNames
+	if config.Option_AutoDigest {
+		// The file type is only known once the file has been written
+		var tmpCard integrity_fileCard
+		tmpCard.FileInfo = &tmpInfo
+		tmpCard.fullpath = tmpPath
+		if digestNames, err = integ_autoDigestNames(&tmpCard); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = integ_verifyCopy(tmpPath, tmpInfo, digestNames, checksums); err != nil {
+		return nil, nil, err
+	}
+	if err = integ_renameCopy(tmpPath, dstPath); err != nil {
+		return nil, nil, err
+	}
+	return digestNames, checksums, nil
+}
+
+// integ_handleTee writes stdin to a file and stores its checksums, for the tee subcommand
+func integ_handleTee(dstPath string) {
+	digestNames, checksums, err := integ_teeFile(os.Stdin, dstPath)
+	if err != nil {
+		switch config.VerboseLevel {
+		case 0, 1:
+			// Always output errors even if we're 'quiet', leaving out the details of failed checks
+			message := err.Error()
+			if errors.Is(err, errCopyVerifyFailed) {
+				message = errCopyVerifyFailed.Error()
+			}
+			displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("tee FAILED : %s", message))
+		case 2:
+			displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("tee FAILED : %s", err.Error()))
+		}
+		tmpPath := integ_teeTempPath(dstPath)
+		if _, statErr := os.Lstat(tmpPath); statErr == nil {
+			// Always output where the data was kept, as it can't be read again
+			displayFileErrorMessageNoDigest(dstPath, fmt.Sprintf("data kept in %s", tmpPath))
+		}
+		config.returnCode = 13 // Error handling path
+		return
+	}
+	for _, digestName := range digestNames {
+		config.DigestName = digestName
+		switch config.VerboseLevel {
+		case 0:
+			// Don't print anything we're 'quiet'
+		case 1:
+			displayFileMessage(dstPath, "written")
+		case 2:
+			displayFileMessage(dstPath, fmt.Sprintf("%s : written and verified", checksums[digestName]))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package integrity
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/pkg/xattr"
+)
+
+// Ways of handling symlinks found while walking directories, set with --symlinks
+var symlinkModes = []string{"skip", "follow", "record"}
+
+// integ_walkSymlink decides what to do with a symlink found while walking a directory
+// Returns the os.FileInfo to walk the symlink with, or nil if it should be skipped
+func integ_walkSymlink(path string, linkInfo os.FileInfo, ancestors []os.FileInfo) (os.FileInfo, error) {
+	switch config.symlinkMode {
+	case "record":
+		return linkInfo, nil
+	case "follow":
+		targetInfo, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// Always output broken symlinks even if we're 'quiet', as what they pointed to can't be checked
+			displayFileErrorMessageNoDigest(path, "skipping broken symlink")
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, ancestor := range ancestors {
+			if os.SameFile(targetInfo, ancestor) {
+				// Always output loops even if we're 'quiet', as part of the tree isn't being walked
+				displayFileErrorMessageNoDigest(path, "skipping symlink loop")
+				return nil, nil
+			}
+		}
+		return targetInfo, nil
+	default:
+		switch config.VerboseLevel {
+		case 0, 1:
+			// Don't print anything we're 'quiet' / this is not an error
+		case 2:
+			target, _ := os.Readlink(path)
+			displayFileMessageNoDigest(path, fmt.Sprintf("skipping symlink to %s", target))
+		}
+		return nil, nil
+	}
+}
+
+// integ_recordsLink reports whether a path is a symlink which holds the checksum of its own target, with --symlinks=record
+func integ_recordsLink(path string) bool {
+	if config.symlinkMode != "record" {
+		return false
+	}
+	fileinfo, err := os.Lstat(path)
+	return err == nil && fileinfo.Mode()&os.ModeSymlink != 0
+}
+
+// integ_linkChecksum calculates the checksum of the target a symlink points to, rather than the target's contents
+func integ_linkChecksum(path string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	hashObj, exists := config.digestList[config.DigestName]
+	if !exists || !hashObj.Available() {
+		return "", fmt.Errorf("digest %s is not supported for symlinks", config.DigestName)
+	}
+	hashFunc := hashObj.New()
+	hashFunc.Write([]byte(target))
+	return hex.EncodeToString(hashFunc.Sum(nil)), nil
+}
+
+// integ_xattrGet reads an extended attribute, from the symlink itself when recording symlinks
+func integ_xattrGet(path string, name string) ([]byte, error) {
+	if integ_recordsLink(path) {
+		return xattr.LGet(path, name)
+	}
+	return xattr.Get(path, name)
+}
+
+// integ_xattrSet writes an extended attribute, to the symlink itself when recording symlinks
+// Not all systems support extended attributes on symlinks, e.g. Linux doesn't allow user attributes on them
+func integ_xattrSet(path string, name string, data []byte) error {
+	if integ_recordsLink(path) {
+		return xattr.LSet(path, name, data)
+	}
+	return xattr.Set(path, name, data)
+}
+
+// integ_xattrRemove removes an extended attribute, from the symlink itself when recording symlinks
+func integ_xattrRemove(path string, name string) error {
+	if integ_recordsLink(path) {
+		return xattr.LRemove(path, name)
+	}
+	return xattr.Remove(path, name)
+}
@@ -0,0 +1,256 @@
+package integrity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/xattr"
+)
+
+// Placeholder used in a directory's tree for files without a stored checksum
+const treeMissingChecksum = "-"
+
+// integ_treeHashFunc returns the hash used to combine a directory's entries
+// the digest's own hash when it is a crypto.Hash, otherwise sha256
+func integ_treeHashFunc() hash.Hash {
+	if hashObj, exists := config.digestList[config.DigestName]; exists && hashObj.Available() {
+		return hashObj.New()
+	}
+	return sha256.New()
+}
+
+// treeDirectory holds the Merkle tree hash of a directory while its entries are being walked
+type treeDirectory struct {
+	path            string
+	hash            hash.Hash
+	firstDifference string
+}
+
+// integ_treeChecksum calculates the Merkle tree hash of a directory for the current digest
+// The hash covers, in name order, the name and stored checksum of every file and the name
+// and tree hash of every sub-directory. Other file types and integrity's sidecar files are ignored,
+// as are paths skipped by the walk's filters. Symlinks are included according to --symlinks.
+//
+// When store is set the tree hash of every directory is written to its extended attributes.
+// Otherwise each directory's tree hash is compared with the stored one, and the path of the
+// first, deepest, directory whose hash differs is returned.
+// Paths below the directory which can't be read are reported and left out of the tree.
+func integ_treeChecksum(dirPath string, store bool) (string, string, error) {
+	// The walk visits each directory before its entries, so the directories being hashed form a stack
+	var stack []*treeDirectory
+	var checksum, firstDifference string
+
+	// closeDirectory finishes the directory at the top of the stack, adding its hash to its parent's
+	closeDirectory := func() error {
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		dirChecksum := hex.EncodeToString(dir.hash.Sum(nil))
+		config.log("debug", "integ_treeChecksum %s : %s\n", dir.path, dirChecksum)
+
+		if store {
+			if err := xattr.Set(dir.path, config.xattribute_fullname, []byte(dirChecksum)); err != nil {
+				return err
+			}
+		} else if dir.firstDifference == "" {
+			// Only compare this directory if nothing below it differs, so we find the deepest difference
+			storedChecksum, _ := integ_getChecksumRaw(dir.path)
+			if storedChecksum != dirChecksum {
+				dir.firstDifference = dir.path
+			}
+		}
+
+		if len(stack) == 0 {
+			checksum, firstDifference = dirChecksum, dir.firstDifference
+			return nil
+		}
+		parent := stack[len(stack)-1]
+		if parent.firstDifference == "" {
+			parent.firstDifference = dir.firstDifference
+		}
+		fmt.Fprintf(parent.hash, "d\x00%s\x00%s\n", filepath.Base(dir.path), dirChecksum)
+		return nil
+	}
+
+	err := integ_walk(dirPath, func(path string, fileinfo os.FileInfo, err error) error {
+		if err != nil {
+			if path == dirPath {
+				return err
+			}
+			return integ_walkError(path, fileinfo, err)
+		}
+		// Finish any directories the walk has moved out of
+		for len(stack) > 0 && filepath.Clean(stack[len(stack)-1].path) != filepath.Dir(path) {
+			if err := closeDirectory(); err != nil {
+				return err
+			}
+		}
+
+		var entryType string
+		switch {
+		case fileinfo.IsDir():
+			stack = append(stack, &treeDirectory{path: path, hash: integ_treeHashFunc()})
+			return nil
+		case fileinfo.Mode().IsRegular() && !integ_isSidecar(fileinfo.Name()):
+			entryType = "f"
+		case fileinfo.Mode()&os.ModeSymlink != 0:
+			// Only passed to us when symlinks are being recorded
+			entryType = "l"
+		default:
+			return nil
+		}
+
+		entryChecksum, err := integ_getChecksumRaw(path)
+		if err != nil {
+			if !strings.Contains(err.Error(), "attribute not found") && !strings.Contains(err.Error(), "no data available") {
+				return integ_walkError(path, fileinfo, err)
+			}
+			entryChecksum = treeMissingChecksum
+			switch config.VerboseLevel {
+			case 0, 1:
+				// Don't print anything, missing checksums are part of the tree
+			case 2:
+				displayFileMessage(path, "no checksum, included in tree as missing")
+			}
+		}
+		fmt.Fprintf(stack[len(stack)-1].hash, "%s\x00%s\x00%s\n", entryType, fileinfo.Name(), entryChecksum)
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	for len(stack) > 0 {
+		if err := closeDirectory(); err != nil {
+			return "", "", err
+		}
+	}
+	return checksum, firstDifference, nil
+}
+
+// integ_removeTreeChecksum removes the tree hash from a directory and every directory below it
+// Returns the number of directories a tree hash was removed from
+func integ_removeTreeChecksum(dirPath string) (int, error) {
+	removed := 0
+	err := integ_walk(dirPath, func(path string, fileinfo os.FileInfo, err error) error {
+		if err != nil {
+			if path == dirPath {
+				return err
+			}
+			return integ_walkError(path, fileinfo, err)
+		}
+		if !fileinfo.IsDir() {
+			return nil
+		}
+		var dirCard integrity_fileCard
+		dirCard.fullpath = path
+		hadAttribute, err := integ_removeChecksum(&dirCard)
+		if hadAttribute {
+			removed++
+		}
+		return err
+	})
+	return removed, err
+}
+
+// integ_handleTree runs the current action against the tree hash of a directory
+func integ_handleTree(dirPath string) {
+	for _, digestName := range config.digestNames {
+		config.DigestName = digestName
+		config.xattribute_fullname = config.xattribute_prefix + config.DigestName
+		config.log("debug", "tree %s: '%s'\n", config.Action, config.xattribute_fullname)
+
+		switch config.Action {
+		case "add":
+			checksum, _, err := integ_treeChecksum(dirPath, true)
+			if err != nil {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Always output errors even if we're 'quiet'
+					displayFileErrorMessage(dirPath, "tree FAILED")
+				case 2:
+					displayFileErrorMessage(dirPath, fmt.Sprintf("tree FAILED : Error adding tree checksum : %s", err.Error()))
+				}
+				continue
+			}
+			switch config.VerboseLevel {
+			case 0:
+				// Don't print anything we're 'quiet'
+			case 1:
+				displayFileMessage(dirPath, "tree added")
+			case 2:
+				displayFileMessage(dirPath, fmt.Sprintf("%s : tree added", checksum))
+			}
+
+		case "check":
+			if _, err := integ_getChecksumRaw(dirPath); err != nil {
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1:
+					displayFileMessage(dirPath, "no tree checksum")
+				case 2:
+					displayFileMessage(dirPath, "no tree checksum, skipped")
+				}
+				continue
+			}
+			checksum, firstDifference, err := integ_treeChecksum(dirPath, false)
+			if err != nil {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Always output errors even if we're 'quiet'
+					displayFileErrorMessage(dirPath, "TREE FAILED")
+				case 2:
+					displayFileErrorMessage(dirPath, fmt.Sprintf("TREE FAILED : Error calculating tree checksum : %s", err.Error()))
+				}
+			} else if firstDifference != "" {
+				// Always output errors even if we're 'quiet'
+				displayFileErrorMessage(dirPath, fmt.Sprintf("TREE FAILED : first difference in %s", firstDifference))
+			} else {
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1:
+					displayFileMessage(dirPath, "TREE PASSED")
+				case 2:
+					displayFileMessage(dirPath, fmt.Sprintf("%s : TREE PASSED", checksum))
+				}
+			}
+
+		case "list":
+			var dirCard integrity_fileCard
+			dirCard.fullpath = dirPath
+			// Errors are displayed by integ_printChecksum
+			_ = integ_printChecksum(&dirCard, dirPath)
+
+		case "delete":
+			removed, err := integ_removeTreeChecksum(dirPath)
+			if err != nil {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Always output errors even if we're 'quiet'
+					displayFileErrorMessage(dirPath, "FAILED")
+				case 2:
+					displayFileErrorMessage(dirPath, fmt.Sprintf("FAILED : Error removing tree checksum : %s", err.Error()))
+				}
+			} else {
+				switch config.VerboseLevel {
+				case 0:
+					// Don't print anything we're 'quiet'
+				case 1:
+					displayFileMessage(dirPath, "tree removed")
+				case 2:
+					displayFileMessage(dirPath, fmt.Sprintf("tree removed from %d directories", removed))
+				}
+			}
+
+		default:
+			config.log("error", "Error : action \"%s\" not supported with --tree\n", config.Action)
+			config.returnCode = 9 // Unknown action
+			return
+		}
+	}
+}
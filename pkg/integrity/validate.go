@@ -0,0 +1,166 @@
+package integrity
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image/jpeg"
+	"io"
+	"os"
+
+	"github.com/mewkiz/flac"
+)
+
+// Returned when a file's format has no built in integrity checks we know how to verify
+var errFormatNotSupported = errors.New("format validation not supported")
+
+// integ_validateFormat verifies a file using the integrity checks built into the file format itself
+// this allows corruption to be detected in files which never had a checksum stored
+// Returns the name of the format which was validated, or errFormatNotSupported
+func integ_validateFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	// Add a function to defer to ensure any issue closing the file is reported
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing file: %s\n", err)
+		}
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	magic := make([]byte, 8)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	magic = magic[:n]
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return "zip", validateZip(f, fi.Size())
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		return "gzip", validateGzip(f)
+	case bytes.HasPrefix(magic, []byte("\x89PNG\r\n\x1a\n")):
+		return "png", validatePNG(f)
+	case bytes.HasPrefix(magic, []byte("fLaC")):
+		return "flac", validateFLAC(f)
+	case bytes.HasPrefix(magic, []byte("\xff\xd8\xff")):
+		return "jpeg", validateJPEG(f)
+	}
+	return "", errFormatNotSupported
+}
+
+// validateZip reads every member of a zip (jar, docx etc) archive, the zip reader verifies each member's CRC32 on reaching EOF
+func validateZip(f io.ReaderAt, size int64) error {
+	archive, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, member := range archive.File {
+		memberReader, err := member.Open()
+		if err != nil {
+			return fmt.Errorf("%s : %w", member.Name, err)
+		}
+		_, err = io.Copy(io.Discard, memberReader)
+		memberReader.Close()
+		if err != nil {
+			return fmt.Errorf("%s : %w", member.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateGzip decompresses every gzip member, the gzip reader verifies the CRC32 and size in each member's trailer
+func validateGzip(f io.Reader) error {
+	gzipReader, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	_, err = io.Copy(io.Discard, gzipReader)
+	return err
+}
+
+// validatePNG verifies the CRC32 stored with every PNG chunk and that the image is not truncated
+func validatePNG(f io.Reader) error {
+	reader := bufio.NewReader(f)
+	// Skip the PNG signature
+	if _, err := reader.Discard(8); err != nil {
+		return err
+	}
+	header := make([]byte, 8)
+	crcBytes := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return errors.New("png: missing IEND chunk, file truncated")
+			}
+			return err
+		}
+		chunkLength := binary.BigEndian.Uint32(header[0:4])
+		chunkType := string(header[4:8])
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:8])
+		if _, err := io.CopyN(crc, reader, int64(chunkLength)); err != nil {
+			return fmt.Errorf("png: chunk %s truncated", chunkType)
+		}
+		if _, err := io.ReadFull(reader, crcBytes); err != nil {
+			return fmt.Errorf("png: chunk %s truncated", chunkType)
+		}
+		if binary.BigEndian.Uint32(crcBytes) != crc.Sum32() {
+			return fmt.Errorf("png: chunk %s CRC mismatch", chunkType)
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+// validateFLAC decodes every audio frame, verifying each frame's CRC, and compares the MD5 of the
+// decoded samples against the MD5 stored in the STREAMINFO block
+func validateFLAC(f io.Reader) error {
+	stream, err := flac.New(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	md5sum := md5.New()
+	for {
+		audioFrame, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		audioFrame.Hash(md5sum)
+	}
+	// An MD5 of all zeros means the encoder didn't calculate one
+	if stream.Info.MD5sum == [md5.Size]uint8{} {
+		return nil
+	}
+	if !bytes.Equal(md5sum.Sum(nil), stream.Info.MD5sum[:]) {
+		return errors.New("flac: decoded audio does not match STREAMINFO MD5")
+	}
+	return nil
+}
+
+// validateJPEG fully decodes the image, JPEG has no checksums so this only detects structural damage
+func validateJPEG(f io.Reader) error {
+	_, err := jpeg.Decode(bufio.NewReader(f))
+	return err
+}
@@ -0,0 +1,124 @@
+package integrity
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// integ_walk walks the directory structure below root calling fn for every file and directory
+// All of integrity's directory walks go through here so they traverse directories the same way
+// Paths excluded by --exclude, --include, .integrityignore files, --skip-system-files or --skip-hidden are skipped,
+// along with everything below excluded directories. With --one-file-system directories on other filesystems are skipped.
+//
+// Symlinks found while walking are handled according to --symlinks, skipped, followed, or passed to fn
+// themselves so their target can be recorded. fn is called in the same way, and in the same order, as
+// by filepath.Walk, with followed symlinks described by their target's os.FileInfo.
+func integ_walk(root string, fn filepath.WalkFunc) error {
+	filter := &walkFilter{root: filepath.Clean(root), ignoreFiles: make(map[string]ignoreRules)}
+	// Symlinks given as the root are always followed, unless they're being recorded
+	var fileinfo os.FileInfo
+	var err error
+	if config.symlinkMode == "record" {
+		fileinfo, err = os.Lstat(root)
+	} else {
+		fileinfo, err = os.Stat(root)
+	}
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		filter.device, _ = integ_deviceID(fileinfo)
+		err = integ_walkPath(root, fileinfo, nil, filter, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// integ_walkPath walks a single path, and everything below it if it's a directory
+// ancestors holds the directories above the path so symlinks looping back to them aren't followed
+func integ_walkPath(path string, fileinfo os.FileInfo, ancestors []os.FileInfo, filter *walkFilter, fn filepath.WalkFunc) error {
+	if !fileinfo.IsDir() {
+		return fn(path, fileinfo, nil)
+	}
+
+	entries, readErr := os.ReadDir(path)
+	if readErr == nil {
+		readErr = filter.loadIgnoreFile(path)
+	}
+	if err := fn(path, fileinfo, readErr); err != nil || readErr != nil {
+		return err
+	}
+
+	ancestors = append(ancestors, fileinfo)
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		entryInfo, err := os.Lstat(entryPath)
+		if err != nil {
+			if err = fn(entryPath, entryInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if reason := filter.excluded(entryPath, entryInfo); reason != "" {
+			switch config.VerboseLevel {
+			case 0, 1:
+				// Don't print anything we're 'quiet' / this is not an error
+			case 2:
+				displayFileMessageNoDigest(entryPath, "skipping "+reason)
+			}
+			continue
+		}
+		if entryInfo.Mode()&os.ModeSymlink != 0 {
+			if entryInfo, err = integ_walkSymlink(entryPath, entryInfo, ancestors); err != nil {
+				if err = fn(entryPath, nil, err); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			} else if entryInfo == nil {
+				continue
+			}
+		}
+		if config.Option_OneFileSystem && entryInfo.IsDir() {
+			if device, known := integ_deviceID(entryInfo); known && device != filter.device {
+				switch config.VerboseLevel {
+				case 0, 1:
+					// Don't print anything we're 'quiet' / this is not an error
+				case 2:
+					displayFileMessageNoDigest(entryPath, "skipping mount point")
+				}
+				continue
+			}
+		}
+		if err = integ_walkPath(entryPath, entryInfo, ancestors, filter, fn); err != nil {
+			if err == filepath.SkipDir {
+				if !entryInfo.IsDir() {
+					// Skip the rest of this directory, as filepath.Walk does
+					return nil
+				}
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// integ_specialFileType describes files which can't be hashed, such as named pipes which would block when read
+// Returns an empty string for regular files, directories and symlinks
+func integ_specialFileType(fileinfo os.FileInfo) string {
+	mode := fileinfo.Mode()
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	case mode&os.ModeIrregular != 0:
+		return "irregular file"
+	}
+	return ""
+}